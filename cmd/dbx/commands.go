@@ -0,0 +1,449 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+// Dropbox refuses files_put uploads above 150 MB.
+const putLimit = 150 * 1024 * 1024
+
+func init() {
+	register(&command{name: "account", help: "show the account", flags: noFlags(0, account)})
+	register(&command{name: "ls", args: "[-l] [path]", help: "list a folder", flags: ls})
+	register(&command{name: "stat", args: "path", help: "show the metadata of a file or folder", flags: noFlags(1, stat)})
+	register(&command{name: "get", args: "[-rev rev] path [local]", help: "download a file, to stdout when local is -", flags: get})
+	register(&command{name: "put", args: "[-parent-rev rev] local path", help: "upload a file", flags: put})
+	register(&command{name: "mkdir", args: "path", help: "create a folder", flags: noFlags(1, mkdir)})
+	register(&command{name: "rm", args: "path", help: "delete a file or folder", flags: noFlags(1, rm)})
+	register(&command{name: "mv", args: "from to", help: "move a file or folder", flags: noFlags(2, mv)})
+	register(&command{name: "cp", args: "from to", help: "copy a file or folder", flags: noFlags(2, cp)})
+	register(&command{name: "search", args: "[-limit n] [-deleted] path query", help: "search file names below a folder", flags: search})
+	register(&command{name: "revs", args: "[-limit n] path", help: "list the revisions of a file", flags: revs})
+	register(&command{name: "restore", args: "path rev", help: "restore a file to a revision", flags: noFlags(2, restore)})
+	register(&command{name: "share", args: "[-long] path", help: "create a shareable link", flags: share})
+	register(&command{name: "media", args: "path", help: "create a direct link to stream a file", flags: noFlags(1, media)})
+	register(&command{name: "thumb", args: "[-format jpeg|png] [-size s|m|l|xl] path [local]", help: "download the thumbnail of an image", flags: thumb})
+	register(&command{name: "delta", args: "[-cursor c] [-all]", help: "list changes since a cursor", flags: delta})
+	register(&command{name: "shell", args: "[-history file]", help: "browse the account interactively", flags: shell})
+}
+
+// noFlags is for commands taking exactly n arguments and no flags.
+func noFlags(n int, fn func(c *cli, args []string) error) func(fs *flag.FlagSet) func(c *cli, args []string) error {
+	return func(fs *flag.FlagSet) func(c *cli, args []string) error {
+		return func(c *cli, args []string) error {
+			if len(args) != n {
+				return errUsage
+			}
+			return fn(c, args)
+		}
+	}
+}
+
+func remotePath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func humanSize(bytes int) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size, unit := float64(bytes), 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+func humanTime(dropboxTime string) string {
+	t, err := time.Parse(time.RFC1123Z, dropboxTime)
+	if err != nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func printContent(w io.Writer, content *dropbox.Content) {
+	name := content.Path
+	if content.Is_dir {
+		fmt.Fprintf(w, "%10s  %16s  %s/\n", "-", humanTime(content.Modified), name)
+	} else {
+		fmt.Fprintf(w, "%10s  %16s  %s\n", humanSize(content.Bytes), humanTime(content.Modified), name)
+	}
+}
+
+func printContents(w io.Writer, contents []dropbox.PathMetadata) {
+	for i := range contents {
+		printContent(w, &contents[i].Content)
+	}
+}
+
+func account(c *cli, args []string) error {
+	info, err := c.api.GetAccountInfo()
+	if err != nil {
+		return err
+	}
+	return c.print(info, func(w io.Writer) {
+		quota := info.Quota_info
+		fmt.Fprintf(w, "name:    %s\nemail:   %s\nuid:     %d\ncountry: %s\n", info.Display_name, info.Email, info.Uid, info.Country)
+		fmt.Fprintf(w, "used:    %s of %s (%s shared)\n", humanSize(int(quota.Normal+quota.Shared)), humanSize(int(quota.Quota)), humanSize(int(quota.Shared)))
+	})
+}
+
+func ls(fs *flag.FlagSet) func(c *cli, args []string) error {
+	long := fs.Bool("l", false, "show sizes and modification times")
+	return func(c *cli, args []string) error {
+		if len(args) > 1 {
+			return errUsage
+		}
+		p := "/"
+		if len(args) == 1 {
+			p = args[0]
+		}
+
+		metadata, err := c.api.GetFileMetadata_(c.api.Root, remotePath(p), 25000, "", true, false, "")
+		if err != nil {
+			return err
+		}
+		sortContents(metadata.Contents)
+
+		return c.print(metadata, func(w io.Writer) {
+			printListing(w, metadata, *long)
+		})
+	}
+}
+
+// sortContents orders a listing by name, ignoring case like Dropbox does.
+func sortContents(contents []dropbox.Content) {
+	sort.Slice(contents, func(i, j int) bool {
+		return strings.ToLower(contents[i].Path) < strings.ToLower(contents[j].Path)
+	})
+}
+
+// printListing prints the names in a folder, or the file itself.
+func printListing(w io.Writer, metadata *dropbox.PathMetadata, long bool) {
+	contents := metadata.Contents
+	if !metadata.Is_dir {
+		contents = []dropbox.Content{metadata.Content}
+	}
+	for i := range contents {
+		switch {
+		case long:
+			printContent(w, &contents[i])
+		case contents[i].Is_dir:
+			fmt.Fprintf(w, "%s/\n", path.Base(contents[i].Path))
+		default:
+			fmt.Fprintf(w, "%s\n", path.Base(contents[i].Path))
+		}
+	}
+}
+
+func stat(c *cli, args []string) error {
+	metadata, err := c.api.GetFileMetadata_(c.api.Root, remotePath(args[0]), 1, "", false, false, "")
+	if err != nil {
+		return err
+	}
+	return c.print(metadata, func(w io.Writer) {
+		fmt.Fprintf(w, "path:     %s\n", metadata.Path)
+		if metadata.Is_dir {
+			fmt.Fprintf(w, "type:     folder\n")
+		} else {
+			fmt.Fprintf(w, "type:     file\nsize:     %s (%d bytes)\nmime:     %s\nrev:      %s\n", metadata.Size, metadata.Bytes, metadata.Mime_type, metadata.Rev)
+		}
+		fmt.Fprintf(w, "modified: %s\n", humanTime(metadata.Modified))
+		if len(metadata.Client_mtime) > 0 {
+			fmt.Fprintf(w, "client:   %s\n", humanTime(metadata.Client_mtime))
+		}
+	})
+}
+
+// createLocal opens the file to download to, stdout for "-".
+func createLocal(c *cli, local string) (io.WriteCloser, error) {
+	if local == "-" {
+		return nopCloser{c.stdout}, nil
+	}
+	return os.Create(local)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func get(fs *flag.FlagSet) func(c *cli, args []string) error {
+	rev := fs.String("rev", "", "revision to download")
+	return func(c *cli, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return errUsage
+		}
+		local := path.Base(remotePath(args[0]))
+		if len(args) == 2 {
+			local = args[1]
+		}
+
+		content, n, err := download(c, remotePath(args[0]), *rev, local)
+		if err != nil || local == "-" {
+			return err
+		}
+		return c.print(content, func(w io.Writer) {
+			fmt.Fprintf(w, "%s -> %s (%s)\n", content.Path, local, humanSize(int(n)))
+		})
+	}
+}
+
+// download streams a file to local, stdout for "-".
+func download(c *cli, remote, rev, local string) (*dropbox.Content, int64, error) {
+	reader, err := c.api.GetFileReader_(c.api.Root, remote, rev)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+
+	out, ioerr := createLocal(c, local)
+	if ioerr != nil {
+		return nil, 0, ioerr
+	}
+	n, ioerr := io.Copy(out, reader)
+	if closeErr := out.Close(); ioerr == nil {
+		ioerr = closeErr
+	}
+	if ioerr != nil {
+		return nil, n, ioerr
+	}
+	return &reader.Content, n, nil
+}
+
+func put(fs *flag.FlagSet) func(c *cli, args []string) error {
+	parentRev := fs.String("parent-rev", "", "revision the upload replaces, a conflicted copy is made when it is not the latest")
+	chunk := fs.Int("chunk", 4*1024*1024, "chunk size of uploads above 150 MB")
+	return func(c *cli, args []string) error {
+		if len(args) != 2 {
+			return errUsage
+		}
+		remote := remotePath(args[1])
+		if strings.HasSuffix(args[1], "/") {
+			remote = path.Join(remote, filepath.Base(args[0]))
+		}
+
+		metadata, err := upload(c, args[0], remote, *parentRev, *chunk)
+		if err != nil {
+			return err
+		}
+		return c.print(metadata, func(w io.Writer) {
+			fmt.Fprintf(w, "%s -> %s (%s)\n", args[0], metadata.Path, humanSize(metadata.Bytes))
+		})
+	}
+}
+
+// upload sends a local file, in chunks when files_put would refuse it. It
+// overwrites unless parentRev is given.
+func upload(c *cli, local, remote, parentRev string, chunk int) (*dropbox.PathMetadata, error) {
+	file, ioerr := os.Open(local)
+	if ioerr != nil {
+		return nil, ioerr
+	}
+	defer file.Close()
+	info, ioerr := file.Stat()
+	if ioerr != nil {
+		return nil, ioerr
+	}
+
+	var metadata *dropbox.PathMetadata
+	var err *dropbox.ApiError
+	if info.Size() > putLimit {
+		metadata, err = c.api.UploadReaderByChunked_(file, c.api.Root, remote, parentRev, len(parentRev) == 0, chunk, 3)
+	} else {
+		metadata, err = c.api.PutFile(file, c.api.Root, remote, parentRev, len(parentRev) == 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// printMetadata prints the result of a file operation.
+func printMetadata(c *cli, metadata *dropbox.PathMetadata, err *dropbox.ApiError) error {
+	if err != nil {
+		return err
+	}
+	return c.print(metadata, func(w io.Writer) {
+		printContent(w, &metadata.Content)
+	})
+}
+
+func mkdir(c *cli, args []string) error {
+	metadata, err := c.api.CreateFolder_(c.api.Root, remotePath(args[0]))
+	return printMetadata(c, metadata, err)
+}
+
+func rm(c *cli, args []string) error {
+	metadata, err := c.api.Delete_(c.api.Root, remotePath(args[0]))
+	return printMetadata(c, metadata, err)
+}
+
+func mv(c *cli, args []string) error {
+	metadata, err := c.api.Move_(c.api.Root, remotePath(args[0]), remotePath(args[1]))
+	return printMetadata(c, metadata, err)
+}
+
+func cp(c *cli, args []string) error {
+	metadata, err := c.api.Copy_(c.api.Root, remotePath(args[0]), remotePath(args[1]), "")
+	return printMetadata(c, metadata, err)
+}
+
+func search(fs *flag.FlagSet) func(c *cli, args []string) error {
+	limit := fs.Int("limit", 1000, "most results")
+	deleted := fs.Bool("deleted", false, "include deleted files")
+	return func(c *cli, args []string) error {
+		if len(args) != 2 {
+			return errUsage
+		}
+		results, err := c.api.Search_(c.api.Root, remotePath(args[0]), args[1], *limit, *deleted)
+		if err != nil {
+			return err
+		}
+		return c.print(results, func(w io.Writer) {
+			printContents(w, *results)
+		})
+	}
+}
+
+func revs(fs *flag.FlagSet) func(c *cli, args []string) error {
+	limit := fs.Int("limit", 10, "most revisions, up to 1000")
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		revisions, err := c.api.Revisions_(c.api.Root, remotePath(args[0]), *limit)
+		if err != nil {
+			return err
+		}
+		return c.print(revisions, func(w io.Writer) {
+			for _, rev := range *revisions {
+				fmt.Fprintf(w, "%-14s %10s  %16s\n", rev.Rev, humanSize(rev.Bytes), humanTime(rev.Modified))
+			}
+		})
+	}
+}
+
+func restore(c *cli, args []string) error {
+	metadata, err := c.api.Restore_(c.api.Root, remotePath(args[0]), args[1])
+	return printMetadata(c, metadata, err)
+}
+
+// printLink prints the url of a shares or media result.
+func printLink(c *cli, link map[string]string, err *dropbox.ApiError) error {
+	if err != nil {
+		return err
+	}
+	return c.print(link, func(w io.Writer) {
+		fmt.Fprintf(w, "%s\n", link["url"])
+		if expires := link["expires"]; len(expires) > 0 {
+			fmt.Fprintf(w, "expires %s\n", humanTime(expires))
+		}
+	})
+}
+
+func share(fs *flag.FlagSet) func(c *cli, args []string) error {
+	long := fs.Bool("long", false, "return the long url instead of a shortened one")
+	return func(c *cli, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		link, err := c.api.Shares_(c.api.Root, remotePath(args[0]), !*long)
+		return printLink(c, link, err)
+	}
+}
+
+func media(c *cli, args []string) error {
+	link, err := c.api.Media_(c.api.Root, remotePath(args[0]))
+	return printLink(c, link, err)
+}
+
+func thumb(fs *flag.FlagSet) func(c *cli, args []string) error {
+	format := fs.String("format", "jpeg", "jpeg or png")
+	size := fs.String("size", "s", "xs, s, m, l or xl")
+	return func(c *cli, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return errUsage
+		}
+		local := strings.TrimSuffix(path.Base(remotePath(args[0])), path.Ext(args[0])) + "." + *format
+		if len(args) == 2 {
+			local = args[1]
+		}
+
+		thumbnail, err := c.api.Thumbnails_(c.api.Root, remotePath(args[0]), *format, *size)
+		if err != nil {
+			return err
+		}
+
+		out, ioerr := createLocal(c, local)
+		if ioerr != nil {
+			return ioerr
+		}
+		_, ioerr = out.Write(thumbnail.DataByte)
+		if closeErr := out.Close(); ioerr == nil {
+			ioerr = closeErr
+		}
+		if ioerr != nil || local == "-" {
+			return ioerr
+		}
+		fmt.Fprintf(c.stderr, "%s -> %s\n", remotePath(args[0]), local)
+		return nil
+	}
+}
+
+func delta(fs *flag.FlagSet) func(c *cli, args []string) error {
+	cursor := fs.String("cursor", "", "cursor of the previous call, everything when empty")
+	all := fs.Bool("all", false, "follow has_more up to the latest cursor")
+	return func(c *cli, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+
+		pages := []*dropbox.DeltaResult{}
+		next := *cursor
+		for {
+			page, err := c.api.Delta(next)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, page)
+			next = page.Cursor
+			if !*all || !page.HasMore {
+				break
+			}
+		}
+
+		return c.print(pages, func(w io.Writer) {
+			for _, page := range pages {
+				if page.Reset {
+					fmt.Fprintf(w, "reset\n")
+				}
+				for _, entry := range page.Entries {
+					if entry.Metadata == nil {
+						fmt.Fprintf(w, "- %s\n", entry.Path)
+					} else {
+						fmt.Fprintf(w, "+ %s\n", entry.Metadata.Path)
+					}
+				}
+			}
+			last := pages[len(pages)-1]
+			fmt.Fprintf(w, "cursor: %s\nhas_more: %t\n", last.Cursor, last.HasMore)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement,
+// history and tab completion. It assumes every rune is one column wide.
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+
+	// complete is given the line up to the cursor, it returns its
+	// replacement and, when that is ambiguous, the candidates to show.
+	complete func(head string) (string, []string)
+}
+
+func (editor *lineEditor) readLine(prompt string) (string, error) {
+	line, pos := []rune{}, 0
+	index, draft := len(editor.history), ""
+
+	redraw := func() {
+		fmt.Fprintf(editor.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(editor.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(s string) {
+		line = []rune(s)
+		pos = len(line)
+	}
+
+	fmt.Fprint(editor.out, prompt)
+	for {
+		r, _, err := editor.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = nil
+			}
+			fmt.Fprint(editor.out, "\n")
+			return string(line), err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(editor.out, "\n")
+			return string(line), nil
+		case 3: // ^C drops the line
+			fmt.Fprint(editor.out, "^C\n")
+			return "", nil
+		case 4: // ^D
+			if len(line) == 0 {
+				fmt.Fprint(editor.out, "\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 1: // ^A
+			pos = 0
+		case 5: // ^E
+			pos = len(line)
+		case 11: // ^K
+			line = line[:pos]
+		case 21: // ^U
+			line, pos = line[pos:], 0
+		case 8, 127:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case '\t':
+			if editor.complete == nil {
+				break
+			}
+			head, candidates := editor.complete(string(line[:pos]))
+			tail := string(line[pos:])
+			setLine(head)
+			line = append(line, []rune(tail)...)
+			if len(candidates) > 0 {
+				fmt.Fprintf(editor.out, "\n%s\n", strings.Join(candidates, "  "))
+			}
+		case 0x1b:
+			key := editor.escape()
+			switch key {
+			case 'A', 'B':
+				if key == 'A' && index > 0 {
+					if index == len(editor.history) {
+						draft = string(line)
+					}
+					index--
+					setLine(editor.history[index])
+				} else if key == 'B' && index < len(editor.history) {
+					index++
+					if index == len(editor.history) {
+						setLine(draft)
+					} else {
+						setLine(editor.history[index])
+					}
+				}
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '3':
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				break
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// escape reads the rest of an escape sequence and returns its final byte,
+// '3' for delete.
+func (editor *lineEditor) escape() byte {
+	b, err := editor.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+	for {
+		b, err = editor.in.ReadByte()
+		if err != nil {
+			return 0
+		}
+		switch {
+		case b == '3':
+			if next, _ := editor.in.ReadByte(); next == '~' {
+				return '3'
+			}
+			return 0
+		case b >= '@' && b <= '~':
+			return b
+		}
+	}
+}
+
+// add appends a line to the history, skipping blanks and repeats.
+func (editor *lineEditor) add(line string) bool {
+	if len(strings.TrimSpace(line)) == 0 {
+		return false
+	}
+	if n := len(editor.history); n > 0 && editor.history[n-1] == line {
+		return false
+	}
+	editor.history = append(editor.history, line)
+	return true
+}
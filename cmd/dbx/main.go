@@ -0,0 +1,170 @@
+// Command dbx runs everyday Dropbox operations from the command line.
+//
+//	dbx [flags] <command> [command flags] [arguments]
+//
+// The access token is read from -token, then the DROPBOX_ACCESS_TOKEN
+// environment variable, then the file given by -token-file. Results are
+// printed for people, or as JSON with -json.
+//
+// dbx shell browses the account interactively, with a current remote folder,
+// tab completion of remote names and a command history.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+type command struct {
+	name  string
+	args  string
+	help  string
+	flags func(fs *flag.FlagSet) func(c *cli, args []string) error
+}
+
+// cli is what commands run with.
+type cli struct {
+	api    *dropbox.DropboxApi
+	json   bool
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = map[string]*command{}
+
+func register(c *command) {
+	commands[c.name] = c
+}
+
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func defaultTokenFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".dbx-token.json"
+	}
+	return filepath.Join(home, ".dbx-token.json")
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("dbx", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	token := global.String("token", "", "access token")
+	tokenFile := global.String("token-file", defaultTokenFile(), "file holding the token as JSON")
+	root := global.String("root", "dropbox", "dropbox or sandbox")
+	locale := global.String("locale", "", "locale of the messages from Dropbox")
+	asJson := global.Bool("json", false, "print results as JSON")
+	apiHost := global.String("api-host", dropbox.DefaultApiHost, "host or base URL of the API")
+	contentHost := global.String("content-host", dropbox.DefaultContentHost, "host or base URL of file contents")
+	global.Usage = func() { usage(global, stderr) }
+
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return 2
+	}
+
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "dbx: unknown command %q\n", global.Arg(0))
+		global.Usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet("dbx "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: dbx %s %s\n\n%s\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	runCmd := cmd.flags(fs)
+	if err := fs.Parse(global.Args()[1:]); err != nil {
+		return 2
+	}
+
+	oauth2, err := loadToken(*token, *tokenFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "dbx: %s\n", err)
+		return 1
+	}
+
+	c := &cli{
+		api: &dropbox.DropboxApi{Signer: oauth2, Root: *root, Locale: *locale,
+			ApiHost: *apiHost, ContentHost: *contentHost},
+		json:   *asJson,
+		stdin:  os.Stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+
+	if err := runCmd(c, fs.Args()); err != nil {
+		if err == errUsage {
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "dbx %s: %s\n", cmd.name, err)
+		return 1
+	}
+	return 0
+}
+
+func usage(global *flag.FlagSet, stderr io.Writer) {
+	fmt.Fprintf(stderr, "usage: dbx [flags] <command> [command flags] [arguments]\n\ncommands:\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(stderr, "  %-8s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintf(stderr, "\nflags:\n")
+	global.PrintDefaults()
+}
+
+func loadToken(token, tokenFile string) (*dropbox.OAuth2, error) {
+	if len(token) > 0 {
+		return &dropbox.OAuth2{AccessToken: token}, nil
+	}
+
+	stores := []dropbox.TokenStore{&dropbox.EnvTokenStore{}}
+	if len(tokenFile) > 0 {
+		stores = append(stores, &dropbox.FileTokenStore{Path: tokenFile})
+	}
+	for _, store := range stores {
+		oauth2, err := store.Load()
+		if err == nil {
+			return oauth2, nil
+		}
+		if err != dropbox.ErrNoToken {
+			return nil, err
+		}
+	}
+	return nil, errors.New("no access token, use -token, DROPBOX_ACCESS_TOKEN or -token-file")
+}
+
+// print writes v as JSON, or calls human to write it for people.
+func (c *cli) print(v interface{}, human func(w io.Writer)) error {
+	if c.json {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	human(c.stdout)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func runWith(t *testing.T, handler http.HandlerFunc, args ...string) (int, string, string) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(append([]string{"-token", "t", "-api-host", server.URL, "-content-host", server.URL}, args...), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestLs(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" {
+			t.Errorf("request signed with %q", r.Header.Get("Authorization"))
+		}
+		if !strings.HasPrefix(r.URL.Path, "/1/metadata/dropbox/") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"path": "/", "is_dir": true, "contents": [
+			{"path": "/b.txt", "bytes": 2048, "modified": "Mon, 02 Jan 2006 15:04:05 +0000"},
+			{"path": "/A", "is_dir": true}]}`))
+	}
+
+	code, stdout, stderr := runWith(t, handler, "ls", "/")
+	if code != 0 || stdout != "A/\nb.txt\n" {
+		t.Errorf("ls exited %d with %q, %q", code, stdout, stderr)
+	}
+
+	code, stdout, _ = runWith(t, handler, "ls", "-l")
+	if code != 0 || !strings.Contains(stdout, "2.0 KB") {
+		t.Errorf("ls -l exited %d with %q", code, stdout)
+	}
+
+	code, stdout, _ = runWith(t, handler, "-json", "ls")
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(stdout), &metadata); code != 0 || err != nil || metadata["Path"] != "/" {
+		t.Errorf("ls -json exited %d with %q", code, stdout)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	notFound := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Path '/missing' not found"}`))
+	}
+
+	if code, _, stderr := runWith(t, notFound, "stat", "/missing"); code != 1 || !strings.Contains(stderr, "not found") {
+		t.Errorf("stat of a missing file exited %d with %q", code, stderr)
+	}
+	if code, _, _ := runWith(t, notFound, "mv", "/a"); code != 2 {
+		t.Errorf("mv with one argument exited %d", code)
+	}
+	if code, _, stderr := runWith(t, notFound, "frobnicate"); code != 2 || !strings.Contains(stderr, "unknown command") {
+		t.Errorf("unknown command exited %d with %q", code, stderr)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	cases := map[int]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 5 * 1024 * 1024: "5.0 MB"}
+	for bytes, want := range cases {
+		if got := humanSize(bytes); got != want {
+			t.Errorf("humanSize(%d) = %q, want %q", bytes, got, want)
+		}
+	}
+}
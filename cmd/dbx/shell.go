@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+// historySize is how many lines of history the shell keeps.
+const historySize = 1000
+
+type shellCommand struct {
+	args string
+	help string
+	// operands tells what each argument completes to, r for remote and l
+	// for local paths.
+	operands string
+	run      func(s *dbxShell, args []string) error
+}
+
+var shellCommands = map[string]*shellCommand{}
+
+var errExit = errors.New("exit")
+
+func init() {
+	shellCommands["cd"] = &shellCommand{args: "[path]", help: "change the remote folder", operands: "r", run: (*dbxShell).cd}
+	shellCommands["pwd"] = &shellCommand{help: "show the remote folder", run: (*dbxShell).pwd}
+	shellCommands["ls"] = &shellCommand{args: "[-l] [path]", help: "list a remote folder", operands: "r", run: (*dbxShell).ls}
+	shellCommands["get"] = &shellCommand{args: "path [local]", help: "download a file", operands: "rl", run: (*dbxShell).get}
+	shellCommands["put"] = &shellCommand{args: "local [path]", help: "upload a file", operands: "lr", run: (*dbxShell).put}
+	shellCommands["lcd"] = &shellCommand{args: "[dir]", help: "change the local folder", operands: "l", run: (*dbxShell).lcd}
+	shellCommands["lpwd"] = &shellCommand{help: "show the local folder", run: (*dbxShell).lpwd}
+	shellCommands["history"] = &shellCommand{help: "show the command history", run: (*dbxShell).showHistory}
+	shellCommands["help"] = &shellCommand{help: "list the commands", run: (*dbxShell).help}
+	shellCommands["exit"] = &shellCommand{help: "leave the shell", run: (*dbxShell).exit}
+	shellCommands["quit"] = shellCommands["exit"]
+}
+
+// dbxShell is an ftp style session with a current remote folder. Folder
+// listings are cached for tab completion and revalidated by hash on ls and cd.
+type dbxShell struct {
+	c           *cli
+	cwd         string
+	listings    map[string]*dropbox.PathMetadata // by lower-cased path
+	editor      *lineEditor
+	historyFile string
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".dbx_history")
+}
+
+func shell(fs *flag.FlagSet) func(c *cli, args []string) error {
+	historyFile := fs.String("history", defaultHistoryFile(), "file to keep the command history in, none when empty")
+	return func(c *cli, args []string) error {
+		if len(args) != 0 {
+			return errUsage
+		}
+		s := newShell(c, *historyFile)
+		s.loadHistory()
+		return s.loop()
+	}
+}
+
+func newShell(c *cli, historyFile string) *dbxShell {
+	s := &dbxShell{
+		c:           c,
+		cwd:         "/",
+		listings:    map[string]*dropbox.PathMetadata{},
+		historyFile: historyFile,
+	}
+	s.editor = &lineEditor{in: bufio.NewReader(c.stdin), out: c.stdout, complete: s.complete}
+	return s
+}
+
+func (s *dbxShell) loop() error {
+	for {
+		line, err := s.readLine(fmt.Sprintf("dbx:%s> ", s.cwd))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.editor.add(line) {
+			s.saveHistory(line)
+		}
+		if err = s.exec(line); err == errExit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(s.c.stderr, "%s\n", err)
+		}
+	}
+}
+
+// readLine uses the line editor on a terminal and plain lines otherwise.
+func (s *dbxShell) readLine(prompt string) (string, error) {
+	if file, ok := s.c.stdin.(*os.File); ok {
+		if restore, err := makeRaw(file.Fd()); err == nil {
+			defer restore()
+			return s.editor.readLine(prompt)
+		}
+	}
+
+	fmt.Fprint(s.c.stdout, prompt)
+	line, err := s.editor.in.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (s *dbxShell) exec(line string) error {
+	words, _, err := splitWords(line)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	cmd, ok := shellCommands[words[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, try help", words[0])
+	}
+	if err = cmd.run(s, words[1:]); err == errUsage {
+		return fmt.Errorf("usage: %s %s", words[0], cmd.args)
+	}
+	return err
+}
+
+// splitWords splits a command line on blanks, honouring quotes and
+// backslash escapes. start is where the last word begins in line, len(line)
+// when line ends between words.
+func splitWords(line string) (words []string, start int, err error) {
+	word := strings.Builder{}
+	inWord, escaped, quote := false, false, rune(0)
+	start = len(line)
+	begin := func(i int) {
+		if !inWord {
+			inWord, start = true, i
+		}
+	}
+
+	for i, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			begin(i)
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			begin(i)
+			quote = r
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord, start = false, len(line)
+			}
+		default:
+			begin(i)
+			word.WriteRune(r)
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+	if quote != 0 {
+		err = errors.New("unterminated quote")
+	}
+	return words, start, err
+}
+
+// escapeWord quotes what splitWords would split or unquote.
+func escapeWord(word string) string {
+	b := strings.Builder{}
+	for _, r := range word {
+		if strings.ContainsRune(" \t\\\"'", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *dbxShell) resolve(p string) string {
+	if strings.HasPrefix(p, "/") {
+		return remotePath(p)
+	}
+	return remotePath(path.Join(s.cwd, p))
+}
+
+// list returns the listing of a remote folder, from the cache unless refresh
+// is set. Refreshing sends the cached hash, so an unchanged folder is a 304.
+func (s *dbxShell) list(dir string, refresh bool) (*dropbox.PathMetadata, error) {
+	key := strings.ToLower(dir)
+	cached := s.listings[key]
+	if cached != nil && !refresh {
+		return cached, nil
+	}
+
+	hash := ""
+	if cached != nil {
+		hash = cached.Hash
+	}
+	metadata, err := s.c.api.GetFileMetadata_(s.c.api.Root, dir, 25000, hash, true, false, "")
+	if err != nil {
+		if err.Code == http.StatusNotModified && cached != nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	if metadata.Is_dir {
+		sortContents(metadata.Contents)
+		s.listings[key] = metadata
+	}
+	return metadata, nil
+}
+
+// complete completes the last word of head with a command name, or a remote
+// or local path depending on the command and argument.
+func (s *dbxShell) complete(head string) (string, []string) {
+	words, start, _ := splitWords(head)
+	word, index := "", len(words)
+	if start < len(head) {
+		word, index = words[len(words)-1], len(words)-1
+	}
+
+	var names []string
+	var ignoreCase bool
+	if index == 0 {
+		for name := range shellCommands {
+			if strings.HasPrefix(name, word) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	} else if cmd, ok := shellCommands[words[0]]; ok {
+		n := 0
+		for _, arg := range words[1:index] {
+			if !strings.HasPrefix(arg, "-") {
+				n++
+			}
+		}
+		if n < len(cmd.operands) {
+			switch cmd.operands[n] {
+			case 'r':
+				names, ignoreCase = s.remoteNames(word), true
+			case 'l':
+				names = localNames(word)
+			}
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return head, nil
+	case 1:
+		completed := escapeWord(names[0])
+		if !strings.HasSuffix(names[0], "/") {
+			completed += " "
+		}
+		return head[:start] + completed, nil
+	}
+
+	if prefix := commonPrefix(names, ignoreCase); len(prefix) > len(word) {
+		return head[:start] + escapeWord(prefix), nil
+	}
+	dir := word[:strings.LastIndex(word, "/")+1]
+	shown := make([]string, len(names))
+	for i, name := range names {
+		shown[i] = strings.TrimPrefix(name, dir)
+	}
+	return head, shown
+}
+
+// remoteNames lists the entries of a cached remote folder starting with the
+// last element of word, ignoring case.
+func (s *dbxShell) remoteNames(word string) []string {
+	dir := word[:strings.LastIndex(word, "/")+1]
+	base := strings.ToLower(word[len(dir):])
+
+	metadata, err := s.list(s.resolve(dir), false)
+	if err != nil || !metadata.Is_dir {
+		return nil
+	}
+	names := []string{}
+	for _, content := range metadata.Contents {
+		name := path.Base(content.Path)
+		if !strings.HasPrefix(strings.ToLower(name), base) {
+			continue
+		}
+		if content.Is_dir {
+			name += "/"
+		}
+		names = append(names, dir+name)
+	}
+	return names
+}
+
+func localNames(word string) []string {
+	dir := word[:strings.LastIndexAny(word, "/"+string(filepath.Separator))+1]
+	base := word[len(dir):]
+
+	readDir := dir
+	if len(readDir) == 0 {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		names = append(names, dir+name)
+	}
+	return names
+}
+
+// commonPrefix returns the longest prefix of names, spelled as in the first
+// one when case is ignored.
+func commonPrefix(names []string, ignoreCase bool) string {
+	prefix := []rune(names[0])
+	for _, name := range names[1:] {
+		other, i := []rune(name), 0
+		for i < len(prefix) && i < len(other) &&
+			(prefix[i] == other[i] || ignoreCase && unicode.ToLower(prefix[i]) == unicode.ToLower(other[i])) {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
+
+func (s *dbxShell) loadHistory() {
+	if len(s.historyFile) == 0 {
+		return
+	}
+	file, err := os.Open(s.historyFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		s.editor.add(scanner.Text())
+	}
+	if n := len(s.editor.history); n > historySize {
+		s.editor.history = s.editor.history[n-historySize:]
+	}
+}
+
+func (s *dbxShell) saveHistory(line string) {
+	if len(s.historyFile) == 0 {
+		return
+	}
+	file, err := os.OpenFile(s.historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(file, line)
+	file.Close()
+}
+
+func (s *dbxShell) cd(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	dir := "/"
+	if len(args) == 1 {
+		dir = s.resolve(args[0])
+	}
+
+	metadata, err := s.list(dir, true)
+	if err != nil {
+		return err
+	}
+	if !metadata.Is_dir {
+		return fmt.Errorf("%s: not a folder", metadata.Path)
+	}
+	s.cwd = remotePath(metadata.Path)
+	return nil
+}
+
+func (s *dbxShell) pwd(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	fmt.Fprintln(s.c.stdout, s.cwd)
+	return nil
+}
+
+func (s *dbxShell) ls(args []string) error {
+	long := len(args) > 0 && args[0] == "-l"
+	if long {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return errUsage
+	}
+	dir := s.cwd
+	if len(args) == 1 {
+		dir = s.resolve(args[0])
+	}
+
+	metadata, err := s.list(dir, true)
+	if err != nil {
+		return err
+	}
+	return s.c.print(metadata, func(w io.Writer) {
+		printListing(w, metadata, long)
+	})
+}
+
+func (s *dbxShell) get(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	remote := s.resolve(args[0])
+	local := path.Base(remote)
+	if len(args) == 2 {
+		local = args[1]
+		if info, err := os.Stat(local); err == nil && info.IsDir() {
+			local = filepath.Join(local, path.Base(remote))
+		}
+	}
+
+	content, n, err := download(s.c, remote, "", local)
+	if err != nil {
+		return err
+	}
+	return s.c.print(content, func(w io.Writer) {
+		fmt.Fprintf(w, "%s -> %s (%s)\n", content.Path, local, humanSize(int(n)))
+	})
+}
+
+func (s *dbxShell) put(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	remote := path.Join(s.cwd, filepath.Base(args[0]))
+	if len(args) == 2 {
+		remote = s.resolve(args[1])
+		if strings.HasSuffix(args[1], "/") || s.isDir(remote) {
+			remote = path.Join(remote, filepath.Base(args[0]))
+		}
+	}
+
+	metadata, err := upload(s.c, args[0], remote, "", 4*1024*1024)
+	if err != nil {
+		return err
+	}
+	delete(s.listings, strings.ToLower(path.Dir(remotePath(metadata.Path))))
+	return s.c.print(metadata, func(w io.Writer) {
+		fmt.Fprintf(w, "%s -> %s (%s)\n", args[0], metadata.Path, humanSize(metadata.Bytes))
+	})
+}
+
+// isDir looks remote up in the cached listing of its parent.
+func (s *dbxShell) isDir(remote string) bool {
+	if remote == "/" {
+		return true
+	}
+	parent, err := s.list(path.Dir(remote), false)
+	if err != nil {
+		return false
+	}
+	for _, content := range parent.Contents {
+		if strings.EqualFold(content.Path, remote) {
+			return content.Is_dir
+		}
+	}
+	return false
+}
+
+func (s *dbxShell) lcd(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	dir, err := os.UserHomeDir()
+	if len(args) == 1 {
+		dir, err = args[0], nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chdir(dir); err != nil {
+		return err
+	}
+	return s.lpwd(nil)
+}
+
+func (s *dbxShell) lpwd(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.c.stdout, "local folder is %s\n", dir)
+	return nil
+}
+
+func (s *dbxShell) showHistory(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	for i, line := range s.editor.history {
+		fmt.Fprintf(s.c.stdout, "%5d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func (s *dbxShell) help(args []string) error {
+	names := []string{}
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := shellCommands[name]
+		fmt.Fprintf(s.c.stdout, "  %-20s %s\n", strings.TrimSpace(name+" "+cmd.args), cmd.help)
+	}
+	return nil
+}
+
+func (s *dbxShell) exit(args []string) error {
+	return errExit
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+func TestSplitWords(t *testing.T) {
+	cases := []struct {
+		line  string
+		words []string
+		start int
+	}{
+		{"ls", []string{"ls"}, 0},
+		{"get  a.txt ", []string{"get", "a.txt"}, 11},
+		{`cd My\ Files`, []string{"cd", "My Files"}, 3},
+		{`put "a b.txt" 'c\d'`, []string{"put", "a b.txt", `c\d`}, 14},
+		{`cd "Open Fo`, []string{"cd", "Open Fo"}, 3},
+	}
+
+	for _, c := range cases {
+		words, start, _ := splitWords(c.line)
+		if !reflect.DeepEqual(words, c.words) || start != c.start {
+			t.Errorf("splitWords(%q) = %q, %d, want %q, %d", c.line, words, start, c.words, c.start)
+		}
+		if words, _, _ := splitWords(escapeWord(c.line)); len(words) != 1 || words[0] != c.line {
+			t.Errorf("escapeWord(%q) splits into %q", c.line, words)
+		}
+	}
+
+	if _, _, err := splitWords(`cd "Open`); err == nil {
+		t.Error("an unterminated quote split without error")
+	}
+}
+
+// newTestShell runs a shell against a fake metadata endpoint serving a root
+// with a file and two folders, it counts the listings fetched.
+func newTestShell(t *testing.T, fetched map[string]int) (*dbxShell, *bytes.Buffer) {
+	listings := map[string]string{
+		"/":          `{"path": "/", "is_dir": true, "hash": "h1", "contents": [{"path": "/Photos", "is_dir": true}, {"path": "/Public", "is_dir": true}, {"path": "/notes.txt", "bytes": 3}]}`,
+		"/photos":    `{"path": "/Photos", "is_dir": true, "hash": "h2", "contents": [{"path": "/Photos/Summer 2020", "is_dir": true}]}`,
+		"/notes.txt": `{"path": "/notes.txt", "bytes": 3}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/1/metadata/dropbox/"))
+		if len(p) == 0 {
+			p = "/"
+		}
+		fetched[p]++
+		body, ok := listings[p]
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "not found"}`))
+		case len(r.URL.Query().Get("hash")) > 0 && strings.Contains(body, `"hash": "`+r.URL.Query().Get("hash")+`"`):
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Write([]byte(body))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	stdout := &bytes.Buffer{}
+	c := &cli{
+		api:    &dropbox.DropboxApi{Signer: &dropbox.OAuth2{AccessToken: "t"}, Root: "dropbox", ApiHost: server.URL, ContentHost: server.URL},
+		stdin:  strings.NewReader(""),
+		stdout: stdout,
+		stderr: stdout,
+	}
+	return newShell(c, ""), stdout
+}
+
+func TestShellComplete(t *testing.T) {
+	fetched := map[string]int{}
+	s, _ := newTestShell(t, fetched)
+
+	cases := []struct {
+		head, completed string
+		candidates      []string
+	}{
+		{"hi", "history ", nil},
+		{"l", "l", []string{"lcd", "lpwd", "ls"}},
+		{"cd p", "cd p", []string{"Photos/", "Public/"}},
+		{"cd Ph", "cd Photos/", nil},
+		{"cd Pu", "cd Public/", nil},
+		{"ls -l Photos/s", `ls -l Photos/Summer\ 2020/`, nil},
+		{"get /n", "get /notes.txt ", nil},
+		{"put notes.txt P", "put notes.txt P", []string{"Photos/", "Public/"}},
+		{"pwd x", "pwd x", nil},
+	}
+	for _, c := range cases {
+		completed, candidates := s.complete(c.head)
+		if completed != c.completed || !reflect.DeepEqual(candidates, c.candidates) {
+			t.Errorf("complete(%q) = %q, %q, want %q, %q", c.head, completed, candidates, c.completed, c.candidates)
+		}
+	}
+	if fetched["/"] != 1 || fetched["/photos"] != 1 {
+		t.Errorf("completion fetched the listings %v times", fetched)
+	}
+}
+
+func TestShellCommands(t *testing.T) {
+	fetched := map[string]int{}
+	s, stdout := newTestShell(t, fetched)
+
+	for _, line := range []string{"ls", `cd "photos"`, "pwd", "cd ..", "cd notes.txt", "cd missing", "frob"} {
+		if err := s.exec(line); err != nil {
+			stdout.WriteString(err.Error() + "\n")
+		}
+	}
+
+	want := "notes.txt\nPhotos/\nPublic/\n/Photos\n/notes.txt: not a folder\n"
+	if out := stdout.String(); !strings.HasPrefix(out, want) || !strings.Contains(out, "not found") || !strings.Contains(out, `unknown command "frob"`) {
+		t.Errorf("commands printed %q", out)
+	}
+	if s.cwd != "/" {
+		t.Errorf("cd .. left the shell in %s", s.cwd)
+	}
+	// ls fetched the root, cd .. revalidated it by hash
+	if fetched["/"] != 2 || len(s.listings) != 2 {
+		t.Errorf("fetched %v, cached %d listings", fetched, len(s.listings))
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	out := &bytes.Buffer{}
+	editor := &lineEditor{
+		in:      bufio.NewReader(strings.NewReader("ls\x1b[D\x1b[Dx\r\x1b[A\x1b[A\x1b[B\x1b[A\r" + "cd Ph\t\r" + "abc\x7f\x01d\x04\r")),
+		out:     out,
+		history: []string{"pwd"},
+		complete: func(head string) (string, []string) {
+			return strings.Replace(head, "Ph", "Photos/", 1), nil
+		},
+	}
+
+	for _, want := range []string{"xls", "pwd", "cd Photos/", "db"} {
+		line, err := editor.readLine("> ")
+		if err != nil || line != want {
+			t.Errorf("read %q, %v, want %q", line, err, want)
+		}
+		editor.add(line)
+	}
+	if _, err := editor.readLine("> "); err == nil {
+		t.Error("reading past the input did not fail")
+	}
+	if !reflect.DeepEqual(editor.history, []string{"pwd", "xls", "pwd", "cd Photos/", "db"}) {
+		t.Errorf("history is %q", editor.history)
+	}
+}
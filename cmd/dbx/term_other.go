@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// makeRaw is not supported here, the shell reads plain lines instead.
+func makeRaw(fd uintptr) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
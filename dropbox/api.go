@@ -0,0 +1,87 @@
+package dropbox
+
+import (
+	"io"
+)
+
+// The interfaces below group the calls of DropboxApi by what they do, so
+// code needing only some of them can take the smallest one and be tested
+// with dropboxtest.Mock. Api is all of them.
+
+// MetadataApi reads the metadata of files and folders.
+type MetadataApi interface {
+	GetFileMetadata(path string) (*PathMetadata, *ApiError)
+	GetFileMetadata_(root, path string, file_limit int, hash string, list, include_deleted bool, rev string) (*PathMetadata, *ApiError)
+	Revisions(path string) (*[]PathMetadata, *ApiError)
+	Revisions_(root, path string, rev_limit int) (*[]PathMetadata, *ApiError)
+	Search(path, query string) (*[]PathMetadata, *ApiError)
+	Search_(root, path, query string, file_limit int, include_deleted bool) (*[]PathMetadata, *ApiError)
+}
+
+// FilesApi downloads and uploads files.
+type FilesApi interface {
+	GetFile(path string) (*FileEntry, *ApiError)
+	GetFile_(root, path, rev string) (*FileEntry, *ApiError)
+	GetFileReader(path string) (*FileReader, *ApiError)
+	GetFileReader_(root, path, rev string) (*FileReader, *ApiError)
+	GetFileRange_(root, path, rev string, offset, length int64) (*FileReader, *ApiError)
+	Thumbnails(path string) (*FileEntry, *ApiError)
+	Thumbnails_(root, path, format, size string) (*FileEntry, *ApiError)
+	PutFileByName(localFilePath, path string) (*PathMetadata, *ApiError)
+	PutFileByName_(localFilePath, root, path string) (*PathMetadata, *ApiError)
+	PutFileByReader(body io.Reader, root, path string) (*PathMetadata, *ApiError)
+	PutFile(body io.Reader, root, path, parent_rev string, overwrite bool) (*PathMetadata, *ApiError)
+	Restore(path, rev string) (*PathMetadata, *ApiError)
+	Restore_(root, path, rev string) (*PathMetadata, *ApiError)
+	UploadByChunked(localPath, path string, trunkSize, retryCount int) (*PathMetadata, *ApiError)
+	UploadReaderByChunked(file io.Reader, path string, trunkSize, retryCount int) (*PathMetadata, *ApiError)
+	UploadReaderByChunked_(file io.Reader, root, path, parent_rev string, overwrite bool, trunkSize, retryCount int) (*PathMetadata, *ApiError)
+	ChunkedUpload(body io.Reader, length int64, upload_id string, offset int) (*ChunkedUploadRes, *ApiError)
+	CommitChunkedUpload_(root, path, upload_id, parent_rev string, overwrite bool) (*PathMetadata, *ApiError)
+}
+
+// FileopsApi copies, moves and deletes files and creates folders.
+type FileopsApi interface {
+	Copy(from_path, to_path string) (*PathMetadata, *ApiError)
+	Copy_(root, from_path, to_path, from_copy_ref string) (*PathMetadata, *ApiError)
+	CreateFolder(path string) (*PathMetadata, *ApiError)
+	CreateFolder_(root, path string) (*PathMetadata, *ApiError)
+	Delete(path string) (*PathMetadata, *ApiError)
+	Delete_(root, path string) (*PathMetadata, *ApiError)
+	Move(from_path, to_path string) (*PathMetadata, *ApiError)
+	Move_(root, from_path, to_path string) (*PathMetadata, *ApiError)
+}
+
+// SharingApi makes links and copy refs to files.
+type SharingApi interface {
+	Shares(path string) (map[string]string, *ApiError)
+	Shares_(root, path string, short_url bool) (map[string]string, *ApiError)
+	CopyRef(path string) (map[string]string, *ApiError)
+	CopyRef_(root, path string) (map[string]string, *ApiError)
+	Media(path string) (map[string]string, *ApiError)
+	Media_(root, path string) (map[string]string, *ApiError)
+}
+
+// DeltaApi follows the changes of a Dropbox.
+type DeltaApi interface {
+	Delta(cursor string) (*DeltaResult, *ApiError)
+}
+
+// AccountApi reads the account and manages the access token.
+type AccountApi interface {
+	GetAccountInfo() (*AccountInfo, *ApiError)
+	ValidateToken() *ApiError
+	RevokeToken() *ApiError
+}
+
+// Api is every call of DropboxApi.
+type Api interface {
+	MetadataApi
+	FilesApi
+	FileopsApi
+	SharingApi
+	DeltaApi
+	AccountApi
+}
+
+var _ Api = (*DropboxApi)(nil)
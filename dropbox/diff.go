@@ -0,0 +1,260 @@
+package dropbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DiffAdded       = "added"        // only in the local directory
+	DiffRemoved     = "removed"      // only on Dropbox
+	DiffModified    = "modified"     // files on both sides that differ
+	DiffTypeChanged = "type_changed" // a file on one side, a folder on the other
+)
+
+type DiffEntry struct {
+	Path        string     `json:"path"`
+	Kind        string     `json:"kind"`
+	Reason      string     `json:"reason,omitempty"` // why a file is modified: size, mtime or content
+	LocalIsDir  bool       `json:"local_is_dir,omitempty"`
+	RemoteIsDir bool       `json:"remote_is_dir,omitempty"`
+	LocalSize   int64      `json:"local_size,omitempty"`
+	RemoteSize  int64      `json:"remote_size,omitempty"`
+	LocalMtime  *time.Time `json:"local_mtime,omitempty"`
+	RemoteMtime *time.Time `json:"remote_mtime,omitempty"`
+	RemoteRev   string     `json:"remote_rev,omitempty"`
+}
+
+// TreeDiff lists how a local directory differs from a Dropbox folder. The
+// content of an added, removed or type changed folder is not listed.
+type TreeDiff struct {
+	LocalDir    string       `json:"local_dir"`
+	RemotePath  string       `json:"remote_path"`
+	Added       []*DiffEntry `json:"added"`
+	Removed     []*DiffEntry `json:"removed"`
+	Modified    []*DiffEntry `json:"modified"`
+	TypeChanged []*DiffEntry `json:"type_changed"`
+}
+
+func (diff *TreeDiff) Empty() bool {
+	return len(diff.Added)+len(diff.Removed)+len(diff.Modified)+len(diff.TypeChanged) == 0
+}
+
+func (diff *TreeDiff) JSON() ([]byte, error) {
+	return json.MarshalIndent(diff, "", "  ")
+}
+
+type diffSide struct {
+	rel   string
+	local string
+	isDir bool
+	size  int64
+	mtime time.Time
+	rev   string
+	path  string // remote path
+}
+
+func (api *DropboxApi) Diff(localDir, remotePath string) (*TreeDiff, *ApiError) {
+	return api.Diff_(localDir, api.Root, remotePath, false, nil)
+}
+
+// Diff_ compares localDir with remotePath by path, size and modification
+// time. With compareHash, files of the same size are compared by content
+// instead of time, which downloads them.
+func (api *DropboxApi) Diff_(localDir, root, remotePath string, compareHash bool, rules *IgnoreRules) (*TreeDiff, *ApiError) {
+	if err := checkRootAndPath(root, remotePath); err != nil {
+		return nil, err
+	}
+
+	if rules != nil {
+		if ioerr := rules.LoadLocal(localDir); ioerr != nil {
+			return nil, api.toApiError(ioerr)
+		}
+	}
+
+	locals, ioerr := diffLocalTree(localDir, rules)
+	if ioerr != nil {
+		return nil, api.toApiError(ioerr)
+	}
+
+	remotes, err := api.diffRemoteTree(root, remotePath, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for k := range locals {
+		keys = append(keys, k)
+	}
+	for k := range remotes {
+		if _, ok := locals[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	diff := &TreeDiff{LocalDir: localDir, RemotePath: remotePath}
+	collapsed := []string{}
+
+	for _, key := range keys {
+		if isUnderAny(key, collapsed) {
+			continue
+		}
+
+		local, remote := locals[key], remotes[key]
+		entry := newDiffEntry(local, remote)
+
+		switch {
+		case remote == nil:
+			entry.Kind, diff.Added = DiffAdded, append(diff.Added, entry)
+		case local == nil:
+			entry.Kind, diff.Removed = DiffRemoved, append(diff.Removed, entry)
+		case local.isDir != remote.isDir:
+			entry.Kind, diff.TypeChanged = DiffTypeChanged, append(diff.TypeChanged, entry)
+		case local.isDir:
+			continue
+		default:
+			reason, err := api.diffFiles(root, local, remote, compareHash)
+			if err != nil {
+				return diff, err
+			}
+			if len(reason) > 0 {
+				entry.Kind, entry.Reason = DiffModified, reason
+				diff.Modified = append(diff.Modified, entry)
+			}
+			continue
+		}
+
+		if (local != nil && local.isDir) || (remote != nil && remote.isDir) {
+			collapsed = append(collapsed, key)
+		}
+	}
+	return diff, nil
+}
+
+func newDiffEntry(local, remote *diffSide) *DiffEntry {
+	entry := &DiffEntry{}
+	if remote != nil {
+		entry.Path, entry.RemoteIsDir, entry.RemoteRev = remote.rel, remote.isDir, remote.rev
+		if !remote.isDir {
+			mtime := remote.mtime
+			entry.RemoteSize, entry.RemoteMtime = remote.size, &mtime
+		}
+	}
+	if local != nil {
+		entry.Path, entry.LocalIsDir = local.rel, local.isDir
+		if !local.isDir {
+			mtime := local.mtime
+			entry.LocalSize, entry.LocalMtime = local.size, &mtime
+		}
+	}
+	return entry
+}
+
+// diffFiles returns why two files differ, or "" when they don't.
+func (api *DropboxApi) diffFiles(root string, local, remote *diffSide, compareHash bool) (string, *ApiError) {
+	if local.size != remote.size {
+		return "size", nil
+	}
+
+	if !compareHash {
+		if local.mtime.Unix() != remote.mtime.Unix() {
+			return "mtime", nil
+		}
+		return "", nil
+	}
+
+	localHash, ioerr := hashFile(local.local)
+	if ioerr != nil {
+		return "", api.toApiError(ioerr)
+	}
+	remoteHash, err := api.hashRemote(root, remote.path, remote.rev)
+	if err != nil {
+		return "", err
+	}
+	if remoteHash != localHash {
+		return "content", nil
+	}
+	return "", nil
+}
+
+// hashRemote is hashFile for a file on Dropbox, hashed while it downloads.
+func (api *DropboxApi) hashRemote(root, path, rev string) (string, *ApiError) {
+	reader, err := api.GetFileReader_(root, path, rev)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, ioerr := io.Copy(hash, reader); ioerr != nil {
+		return "", api.toApiError(ioerr)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func diffLocalTree(localDir string, rules *IgnoreRules) (map[string]*diffSide, error) {
+	sides := make(map[string]*diffSide)
+
+	err := filepath.Walk(localDir, func(local string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if local == localDir {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(localDir, local)
+		rel = filepath.ToSlash(rel)
+		if rules.Ignored(rel, info.IsDir(), info.Size()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() || info.Mode().IsRegular() {
+			sides[strings.ToLower(rel)] = &diffSide{rel: rel, local: local, isDir: info.IsDir(),
+				size: info.Size(), mtime: info.ModTime()}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return sides, err
+}
+
+func (api *DropboxApi) diffRemoteTree(root, remotePath string, rules *IgnoreRules) (map[string]*diffSide, *ApiError) {
+	sides := make(map[string]*diffSide)
+	prefix := cleanRemotePath(remotePath)
+
+	err := api.Walk(root, remotePath, rules, func(p string, metadata *PathMetadata, err *ApiError) *ApiError {
+		if err != nil {
+			// a missing remote folder is an empty one.
+			if err.Code == http.StatusNotFound && cleanRemotePath(p) == prefix {
+				return nil
+			}
+			return err
+		}
+
+		rel, ok := relRemotePath(prefix, metadata.Path)
+		if !ok || len(rel) == 0 {
+			return nil
+		}
+
+		mtime, _ := metadata.mtime()
+		sides[strings.ToLower(rel)] = &diffSide{rel: rel, isDir: metadata.Is_dir, size: int64(metadata.Bytes),
+			mtime: mtime, rev: metadata.Rev, path: metadata.Path}
+		return nil
+	})
+	return sides, err
+}
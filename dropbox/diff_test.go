@@ -0,0 +1,139 @@
+package dropbox_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+func TestDiffFilesBySizeAndMtime(t *testing.T) {
+	now := time.Unix(1381000000, 0)
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.Now = func() time.Time { return now }
+
+	local := t.TempDir()
+	cases := []struct {
+		name, content string
+		mtime         time.Time
+	}{
+		{"size.txt", "abcd", now},
+		{"mtime.txt", "abc", now.Add(time.Minute)},
+		{"same.txt", "abc", now.Add(time.Millisecond)},
+	}
+	for _, c := range cases {
+		server.WriteFile("/d/"+c.name, []byte("abc"))
+		writeLocal(t, local, c.name, c.content)
+		os.Chtimes(filepath.Join(local, c.name), c.mtime, c.mtime)
+	}
+
+	diff, err := server.Api().Diff(local, "/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := diffPaths(diff.Modified); len(got) != 2 || got[0] != "mtime.txt:mtime" || got[1] != "size.txt:size" {
+		t.Errorf("Modified = %v", got)
+	}
+}
+
+func TestTreeDiffJSON(t *testing.T) {
+	diff := &dropbox.TreeDiff{LocalDir: "/tmp/a", RemotePath: "/a"}
+	if !diff.Empty() {
+		t.Errorf("new TreeDiff is not empty")
+	}
+
+	mtime := time.Unix(0, 0)
+	diff.Added = append(diff.Added, &dropbox.DiffEntry{Path: "New.txt", Kind: dropbox.DiffAdded, LocalSize: 5, LocalMtime: &mtime})
+	if diff.Empty() {
+		t.Errorf("TreeDiff with an added entry is empty")
+	}
+
+	data, err := diff.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := map[string]interface{}{}
+	json.Unmarshal(data, &decoded)
+	added, _ := decoded["added"].([]interface{})
+	if len(added) != 1 {
+		t.Fatalf("JSON added = %v", decoded["added"])
+	}
+	entry := added[0].(map[string]interface{})
+	if entry["path"] != "New.txt" || entry["kind"] != "added" || entry["local_size"] != float64(5) {
+		t.Errorf("JSON entry = %v", entry)
+	}
+	if _, ok := entry["remote_mtime"]; ok {
+		t.Errorf("JSON entry has a remote mtime: %v", entry)
+	}
+}
+
+func diffPaths(entries []*dropbox.DiffEntry) []string {
+	paths := []string{}
+	for _, entry := range entries {
+		paths = append(paths, entry.Path+":"+entry.Reason)
+	}
+	return paths
+}
+
+func TestDiff(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.Now = func() time.Time { return time.Unix(1381000000, 0) }
+	server.WriteFile("/d/same.txt", []byte("same"))
+	server.WriteFile("/d/changed.txt", []byte("remote"))
+	server.WriteFile("/d/Remote-only.txt", []byte("r"))
+	server.WriteFile("/d/kind", []byte("a file"))
+	server.WriteFile("/d/folder/x.txt", []byte("x"))
+
+	api := server.Api()
+	local := t.TempDir()
+	writeLocal(t, local, "same.txt", "same")
+	writeLocal(t, local, "changed.txt", "local!")
+	writeLocal(t, local, "local-only.txt", "l")
+	writeLocal(t, local, "kind/inside.txt", "a folder")
+	writeLocal(t, local, "build.log", "ignored")
+
+	diff, err := api.Diff_(local, "dropbox", "/d", true, dropbox.NewIgnoreRules("*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(what string, entries []*dropbox.DiffEntry, want ...string) {
+		got := diffPaths(entries)
+		if len(got) != len(want) {
+			t.Errorf("%s = %v, want %v", what, got, want)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s = %v, want %v", what, got, want)
+			}
+		}
+	}
+	check("Added", diff.Added, "local-only.txt:")
+	check("Removed", diff.Removed, "folder:", "Remote-only.txt:")
+	check("TypeChanged", diff.TypeChanged, "kind:")
+	check("Modified", diff.Modified, "changed.txt:content")
+
+	// by time, the files written now are newer than the remote ones
+	if diff, err = api.Diff(local, "/d"); err != nil {
+		t.Fatal(err)
+	}
+	check("Modified by time", diff.Modified, "changed.txt:mtime", "same.txt:mtime")
+	check("Added without rules", diff.Added, "build.log:", "local-only.txt:")
+
+	remoteTime := time.Unix(1381000000, 0)
+	os.Chtimes(filepath.Join(local, "same.txt"), remoteTime, remoteTime)
+	if diff, _ = api.Diff(local, "/d"); len(diff.Modified) != 1 {
+		t.Errorf("same.txt with the remote time is %v", diffPaths(diff.Modified))
+	}
+
+	if diff, err = api.Diff(local, "/missing"); err != nil || len(diff.Added) != 5 || len(diff.Removed) != 0 {
+		t.Errorf("Diff with a missing remote folder returned %v, %v", diffPaths(diff.Added), err)
+	}
+}
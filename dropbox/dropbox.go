@@ -2,9 +2,12 @@ package dropbox
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -18,47 +21,115 @@ import (
 var (
 	rootRegexp *regexp.Regexp = regexp.MustCompile(`sandbox|dropbox|auto`)
 
+	// apiUrls are the endpoints of the authorization flows, they run before
+	// there is a DropboxApi.
 	apiUrls = map[string]string{
-		"authorize-url": "https://www.dropbox.com/1/oauth2/authorize",
-
-		"account/info":          "https://api.dropbox.com/1/account/info",
-		"metadata":              "https://api.dropbox.com/1/metadata/<root>/<path>",
-		"gets":                  "https://api-content.dropbox.com/1/files/<root>/<path>",
-		"files_put":             "https://api-content.dropbox.com/1/files_put/<root>/<path>",
-		"delta":                 "https://api.dropbox.com/1/delta",
-		"revisions":             "https://api.dropbox.com/1/revisions/<root>/<path>",
-		"restore":               "https://api.dropbox.com/1/restore/<root>/<path>",
-		"search":                "https://api.dropbox.com/1/search/<root>/<path>",
-		"shares":                "https://api.dropbox.com/1/shares/<root>/<path>",
-		"media":                 "https://api.dropbox.com/1/media/<root>/<path>",
-		"copy_ref":              "https://api.dropbox.com/1/copy_ref/<root>/<path>",
-		"thumbnails":            "https://api-content.dropbox.com/1/thumbnails/<root>/<path>",
-		"chunked_upload":        "https://api-content.dropbox.com/1/chunked_upload",
-		"commit_chunked_upload": "https://api-content.dropbox.com/1/commit_chunked_upload/<root>/<path>",
-
-		"fileops/copy":          "https://api.dropbox.com/1/fileops/copy",
-		"fileops/create_folder": "https://api.dropbox.com/1/fileops/create_folder",
-		"fileops/delete":        "https://api.dropbox.com/1/fileops/delete",
-		"fileops/move":          "https://api.dropbox.com/1/fileops/move",
+		"authorize-url": webHost + "/1/oauth2/authorize",
+		"token-url":     DefaultApiHost + "/1/oauth2/token",
+
+		"oauth/request_token": DefaultApiHost + "/1/oauth/request_token",
+		"oauth/authorize":     webHost + "/1/oauth/authorize",
+		"oauth/access_token":  DefaultApiHost + "/1/oauth/access_token",
+	}
+
+	// endpoints are the calls of DropboxApi, below its ApiHost or, for
+	// content, its ContentHost.
+	endpoints = map[string]endpoint{
+		"account/info":          {path: "/1/account/info"},
+		"disable_access_token":  {path: "/1/disable_access_token"},
+		"metadata":              {path: "/1/metadata/<root>/<path>"},
+		"gets":                  {path: "/1/files/<root>/<path>", content: true},
+		"files_put":             {path: "/1/files_put/<root>/<path>", content: true},
+		"delta":                 {path: "/1/delta"},
+		"revisions":             {path: "/1/revisions/<root>/<path>"},
+		"restore":               {path: "/1/restore/<root>/<path>"},
+		"search":                {path: "/1/search/<root>/<path>"},
+		"shares":                {path: "/1/shares/<root>/<path>"},
+		"media":                 {path: "/1/media/<root>/<path>"},
+		"copy_ref":              {path: "/1/copy_ref/<root>/<path>"},
+		"thumbnails":            {path: "/1/thumbnails/<root>/<path>", content: true},
+		"chunked_upload":        {path: "/1/chunked_upload", content: true},
+		"commit_chunked_upload": {path: "/1/commit_chunked_upload/<root>/<path>", content: true},
+
+		"fileops/copy":          {path: "/1/fileops/copy"},
+		"fileops/create_folder": {path: "/1/fileops/create_folder"},
+		"fileops/delete":        {path: "/1/fileops/delete"},
+		"fileops/move":          {path: "/1/fileops/move"},
 	}
 )
 
+const (
+	DefaultApiHost     = "https://api.dropbox.com"
+	DefaultContentHost = "https://api-content.dropbox.com"
+
+	webHost = "https://www.dropbox.com"
+)
+
+type endpoint struct {
+	path    string
+	content bool
+}
+
 type DropboxApi struct {
 	Signer    RequestSinger
 	Root      string // default root path
 	Locale    string
 	ErrorCode int
+	Client    *http.Client // nil for a default client
+
+	// ApiHost and ContentHost are where requests go, DefaultApiHost and
+	// DefaultContentHost when empty. Either a host, reached over https, or
+	// a base URL like http://127.0.0.1:8080 for a proxy or a fake.
+	ApiHost     string
+	ContentHost string
+
+	// Middlewares wrap every request, the first one outermost. Signer runs
+	// after them, right before the request is sent.
+	Middlewares []Middleware
+
+	// Logger logs every request sent, nothing is logged when it is nil.
+	Logger Logger
 }
 
 type ApiError struct {
 	Code     int
 	ErrorMsg string `json:"Error"`
+	// Revoked is set on the 401 of a revoked or expired access token,
+	// errors.Is matches such an error with ErrTokenRevoked.
+	Revoked bool `json:"-"`
 }
 
 func (err ApiError) Error() string {
 	return err.ErrorMsg
 }
 
+// Is lets errors.Is match a 404 with fs.ErrNotExist, a 401 or 403 with
+// fs.ErrPermission and a revoked token with ErrTokenRevoked.
+func (err ApiError) Is(target error) bool {
+	switch target {
+	case ErrTokenRevoked:
+		return err.Revoked
+	case fs.ErrNotExist:
+		return err.Code == http.StatusNotFound
+	case fs.ErrPermission:
+		return err.Code == http.StatusUnauthorized || err.Code == http.StatusForbidden
+	}
+	return false
+}
+
+// ErrTokenRevoked matches, through errors.Is, the errors of requests
+// Dropbox answers with 401: the access token was revoked or expired and the
+// user has to authorize again.
+var ErrTokenRevoked = errors.New("the access token has been revoked .")
+
+// tokenRevoked is a 401 error keeping the message of Dropbox, if any.
+func tokenRevoked(msg string) *ApiError {
+	if len(msg) == 0 {
+		msg = ErrTokenRevoked.Error()
+	}
+	return &ApiError{Code: http.StatusUnauthorized, ErrorMsg: msg, Revoked: true}
+}
+
 type RequestSinger interface {
 	Sign(*http.Request) *ApiError
 }
@@ -100,7 +171,22 @@ type PathMetadata struct {
 }
 
 func (api *DropboxApi) getUrl(name string) string {
-	return apiUrls[name]
+	e := endpoints[name]
+	host, fallback := api.ApiHost, DefaultApiHost
+	if e.content {
+		host, fallback = api.ContentHost, DefaultContentHost
+	}
+	return baseUrl(host, fallback) + e.path
+}
+
+func baseUrl(host, fallback string) string {
+	if len(host) == 0 {
+		return fallback
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return strings.TrimSuffix(host, "/")
 }
 
 func (api *DropboxApi) getRootPathUrl(name, root, path string) string {
@@ -121,28 +207,44 @@ func (api *DropboxApi) getErrorMsg(body []byte, code int) *ApiError {
 }
 
 func AuthorizeUrl(appKey, redirectUrl string) {
-	url := fmt.Sprintf("%s?response_type=token&client_id=%s&redirect_uri=%s", apiUrls["authorize-url"], appKey, redirectUrl)
+	values := url.Values{}
+	values.Add("response_type", "token")
+	values.Add("client_id", appKey)
+	values.Add("redirect_uri", redirectUrl)
 
-	fmt.Printf("%s\n", url)
+	fmt.Printf("%s?%s\n", apiUrls["authorize-url"], values.Encode())
 }
 
 func (api *DropboxApi) doRequest(req *http.Request) (*http.Response, *ApiError) {
-	if api.Signer == nil {
+	if api.Signer == nil && len(api.Middlewares) == 0 {
 		return nil, &ApiError{Code: -1, ErrorMsg: "no Signer found ."}
 	}
 
-	err := api.Signer.Sign(req)
-	if err != nil {
-		return &http.Response{}, err
+	send := api.send
+	if api.Logger != nil {
+		send = api.logged(send)
+	}
+	if api.Signer != nil {
+		send = SignerMiddleware(api.Signer)(send)
+	}
+	for i := len(api.Middlewares) - 1; i >= 0; i-- {
+		send = api.Middlewares[i](send)
 	}
 
-	client := &http.Client{}
+	return send(req)
+}
+
+func (api *DropboxApi) send(req *http.Request) (*http.Response, *ApiError) {
+	client := api.Client
+	if client == nil {
+		client = &http.Client{}
+	}
 
 	resp, httperr := client.Do(req)
 	if httperr != nil {
-		err = api.toApiError(httperr)
+		return resp, api.toApiError(httperr)
 	}
-	return resp, err
+	return resp, nil
 }
 
 func (api *DropboxApi) bytesToJson(bodybytes []byte, jsonObj interface{}) *ApiError {
@@ -222,6 +324,20 @@ func (api *DropboxApi) GetAccountInfo() (*AccountInfo, *ApiError) {
 	return accountInfo, err
 }
 
+// ValidateToken checks the access token with a cheap call, the error
+// matches ErrTokenRevoked when it is no longer valid.
+func (api *DropboxApi) ValidateToken() *ApiError {
+	_, err := api.GetAccountInfo()
+	return err
+}
+
+// RevokeToken disables the access token, requests signed with it fail
+// afterwards.
+func (api *DropboxApi) RevokeToken() *ApiError {
+	res := make(map[string]interface{})
+	return api.jsonReponseByPost(api.getUrl("disable_access_token"), &res)
+}
+
 type FileEntry struct {
 	Content
 	DataByte []byte
@@ -241,6 +357,9 @@ func (api *DropboxApi) getFileEntry(apiurl string) (*FileEntry, *ApiError) {
 	if ioerr != nil {
 		return file, api.toApiError(ioerr)
 	}
+	if resp.StatusCode != http.StatusOK {
+		return file, api.getErrorMsg(bytes, resp.StatusCode)
+	}
 	file.DataByte = bytes
 
 	metadata := resp.Header.Get("x-dropbox-metadata")
@@ -264,6 +383,82 @@ func (api *DropboxApi) GetFile_(root, path, rev string) (*FileEntry, *ApiError)
 	return api.getFileEntry(apiurl)
 }
 
+// FileReader streams the content of a file, it must be closed.
+type FileReader struct {
+	Content // from the x-dropbox-metadata header
+	io.ReadCloser
+}
+
+func (api *DropboxApi) GetFileReader(path string) (*FileReader, *ApiError) {
+	return api.GetFileReader_(api.Root, path, "")
+}
+
+// GetFileReader_ is GetFile_ without reading the whole file in memory.
+func (api *DropboxApi) GetFileReader_(root, path, rev string) (*FileReader, *ApiError) {
+	return api.getFileReader(root, path, rev, -1, -1)
+}
+
+// GetFileRange_ streams length bytes of a file starting at offset, up to the
+// end of the file when length is negative.
+func (api *DropboxApi) GetFileRange_(root, path, rev string, offset, length int64) (*FileReader, *ApiError) {
+	return api.getFileReader(root, path, rev, offset, length)
+}
+
+func (api *DropboxApi) getFileReader(root, path, rev string, offset, length int64) (*FileReader, *ApiError) {
+	if err := checkRootAndPath(root, path); err != nil {
+		return nil, err
+	}
+
+	apiurl := api.getRootPathUrl("gets", root, path)
+	if len(rev) > 0 {
+		apiurl = fmt.Sprintf("%s?rev=%s", apiurl, url.QueryEscape(rev))
+	}
+
+	req, httperr := http.NewRequest("GET", apiurl, nil)
+	if httperr != nil {
+		return nil, api.toApiError(httperr)
+	}
+	if offset >= 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if length >= 0 {
+			byteRange += strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := api.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, api.getErrorMsg(body, resp.StatusCode)
+	}
+
+	file := &FileReader{ReadCloser: resp.Body}
+	if err = api.bytesToJson([]byte(resp.Header.Get("x-dropbox-metadata")), &file.Content); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	// the range was ignored, cut it from the whole file
+	if offset >= 0 && resp.StatusCode == http.StatusOK {
+		if _, ioerr := io.CopyN(ioutil.Discard, resp.Body, offset); ioerr != nil {
+			resp.Body.Close()
+			return nil, api.toApiError(ioerr)
+		}
+		if length >= 0 {
+			file.ReadCloser = struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(resp.Body, length), resp.Body}
+		}
+	}
+	return file, nil
+}
+
 func (api *DropboxApi) Thumbnails(path string) (*FileEntry, *ApiError) {
 	return api.Thumbnails_(api.Root, path, "jpeg", "s")
 }
@@ -419,6 +614,10 @@ func (api *DropboxApi) convert2pathMetadata(val interface{}) *PathMetadata {
 			meta.Thumb_exists = value.Bool()
 		case "modified":
 			meta.Modified = value.String()
+		case "client_mtime":
+			meta.Client_mtime = value.String()
+		case "mime_type":
+			meta.Mime_type = value.String()
 		case "rev":
 			meta.Rev = value.String()
 		case "path":
@@ -574,6 +773,12 @@ func (api *DropboxApi) UploadByChunked(localPath, path string, trunkSize, retryC
 }
 
 func (api *DropboxApi) UploadReaderByChunked(file io.Reader, path string, trunkSize, retryCount int) (*PathMetadata, *ApiError) {
+	return api.UploadReaderByChunked_(file, api.Root, path, "", true, trunkSize, retryCount)
+}
+
+func (api *DropboxApi) UploadReaderByChunked_(file io.Reader, root, path, parent_rev string, overwrite bool,
+	trunkSize, retryCount int) (*PathMetadata, *ApiError) {
+
 	buff := make([]byte, trunkSize)
 	offset, uploadid := 0, ""
 
@@ -593,14 +798,14 @@ func (api *DropboxApi) UploadReaderByChunked(file io.Reader, path string, trunkS
 		offset, uploadid = res.Offset, res.Upload_id
 	}
 
-	return api.commitChunkedUpload(path, uploadid)
+	return api.CommitChunkedUpload_(root, path, uploadid, parent_rev, overwrite)
 }
 
 func (api *DropboxApi) retryUploadTrunk(trunk []byte, upload_id string, offset, retryCount int) (*ChunkedUploadRes, *ApiError) {
 	var res *ChunkedUploadRes
 	var err *ApiError
 	for i := 1; i <= retryCount; i++ {
-		res, err = api.chunkedUpload_(trunk, upload_id, offset)
+		res, err = api.chunkedUpload_(trunk, upload_id, offset, i)
 		if err == nil {
 			return res, nil
 		} else if i == retryCount {
@@ -610,7 +815,19 @@ func (api *DropboxApi) retryUploadTrunk(trunk []byte, upload_id string, offset,
 	return res, err
 }
 
-func (api *DropboxApi) chunkedUpload_(trunk []byte, upload_id string, offset int) (*ChunkedUploadRes, *ApiError) {
+func (api *DropboxApi) chunkedUpload_(trunk []byte, upload_id string, offset, attempt int) (*ChunkedUploadRes, *ApiError) {
+	ctx := WithAttempt(context.Background(), attempt)
+	return api.chunkedUpload(ctx, bytes.NewReader(trunk), int64(len(trunk)), upload_id, offset)
+}
+
+// ChunkedUpload sends length bytes of body as the chunk at offset of an
+// upload, a new upload is started when upload_id is empty. Finish it with
+// CommitChunkedUpload_.
+func (api *DropboxApi) ChunkedUpload(body io.Reader, length int64, upload_id string, offset int) (*ChunkedUploadRes, *ApiError) {
+	return api.chunkedUpload(context.Background(), body, length, upload_id, offset)
+}
+
+func (api *DropboxApi) chunkedUpload(ctx context.Context, body io.Reader, length int64, upload_id string, offset int) (*ChunkedUploadRes, *ApiError) {
 	apiurl := api.getUrl("chunked_upload")
 
 	values := url.Values{}
@@ -620,8 +837,14 @@ func (api *DropboxApi) chunkedUpload_(trunk []byte, upload_id string, offset int
 	values.Add("offset", strconv.Itoa(offset))
 	apiurl = fmt.Sprintf("%s?%s", apiurl, values.Encode())
 
+	req, httperr := http.NewRequestWithContext(ctx, "PUT", apiurl, body)
+	if httperr != nil {
+		return nil, api.toApiError(httperr)
+	}
+	req.ContentLength = length
+
 	metadata := &ChunkedUploadRes{}
-	resp, err := api.doPut(bytes.NewBuffer(trunk), apiurl)
+	resp, err := api.doRequest(req)
 	if err == nil {
 		defer resp.Body.Close()
 		err = api.bodyToJson(resp, metadata)
@@ -630,10 +853,6 @@ func (api *DropboxApi) chunkedUpload_(trunk []byte, upload_id string, offset int
 	return metadata, err
 }
 
-func (api *DropboxApi) commitChunkedUpload(path, upload_id string) (*PathMetadata, *ApiError) {
-	return api.CommitChunkedUpload_(api.Root, path, upload_id, "", true)
-}
-
 func (api *DropboxApi) CommitChunkedUpload_(root, path, upload_id, parent_rev string, overwrite bool) (*PathMetadata, *ApiError) {
 	if err := checkRootAndPath(root, path); err != nil {
 		return nil, err
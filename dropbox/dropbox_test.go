@@ -1,21 +1,42 @@
-package dropbox
+package dropbox_test
 
 import (
-	"testing"
+	"io/ioutil"
 	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
 )
 
 var (
-	accessToken = "" // you access token here
+	accessToken = "" // you access token here, the tests run against a fake without one
+
+	fake *dropboxtest.Server
 )
 
-func getApi() *DropboxApi {
-    if strings.EqualFold("", accessToken) {
-        panic("no accessToken is gived .")
-    }
+func getApi() *dropbox.DropboxApi {
+	if strings.EqualFold("", accessToken) {
+		return getFakeApi()
+	}
 
-	oauth2 := &OAuth2{AccessToken: accessToken}
-	dropboxApi := &DropboxApi{Signer: oauth2, Root: "sandbox", Locale: "CN"}
+	oauth2 := &dropbox.OAuth2{AccessToken: accessToken}
+	dropboxApi := &dropbox.DropboxApi{Signer: oauth2, Root: "sandbox", Locale: "CN"}
+	return dropboxApi
+}
+
+// getFakeApi serves the files the tests expect from one fake, shared by the
+// tests like the sandbox of an account would be.
+func getFakeApi() *dropbox.DropboxApi {
+	if fake == nil {
+		fake = dropboxtest.NewServer()
+		source, _ := ioutil.ReadFile("dropbox.go")
+		fake.WriteFile("/dropbox.go", source)
+		fake.WriteFile("/IMG_20130613_121901.jpg", []byte("jpeg"))
+	}
+
+	dropboxApi := fake.Api()
+	dropboxApi.Root, dropboxApi.Locale = "sandbox", "CN"
 	return dropboxApi
 }
 
@@ -190,3 +211,30 @@ func TestUploadByChunked(t *testing.T) {
 		t.Logf("trunked: %v\n", trunked)
 	}
 }
+
+func TestApiHosts(t *testing.T) {
+	first, second := dropboxtest.NewServer(), dropboxtest.NewServer()
+	defer first.Close()
+	defer second.Close()
+	first.WriteFile("/a.txt", []byte("first"))
+	second.WriteFile("/a.txt", []byte("second"))
+
+	// one client for both, only the hosts differ
+	apis := []*dropbox.DropboxApi{first.Api(), first.Api()}
+	apis[1].ApiHost, apis[1].ContentHost = second.URL, second.URL
+
+	for i, want := range []string{"first", "second"} {
+		file, err := apis[i].GetFile("/a.txt")
+		if err != nil || string(file.DataByte) != want {
+			t.Errorf("api %d read %q, %v", i, file.DataByte, err)
+		}
+	}
+
+	apis[0].ContentHost = "127.0.0.1:1"
+	if _, err := apis[0].GetFile("/a.txt"); err == nil {
+		t.Error("reading through an unreachable content host succeeded")
+	}
+	if _, err := apis[0].GetFileMetadata("/a.txt"); err != nil {
+		t.Errorf("metadata from the api host failed: %v", err)
+	}
+}
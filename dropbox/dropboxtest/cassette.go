@@ -0,0 +1,306 @@
+package dropboxtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Mode tells a Recorder whether to record or replay.
+type Mode int
+
+const (
+	// ModeReplay answers from the cassette and never touches the network.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests on and records them, Save writes the
+	// cassette.
+	ModeRecord
+	// ModeAuto replays when the cassette exists and records otherwise.
+	ModeAuto
+)
+
+// Redacted replaces secrets in cassettes.
+const Redacted = "REDACTED"
+
+// Cassette is what a Recorder keeps in its file.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is kept as a string when it is text and as base64 otherwise.
+type Body []byte
+
+func (body Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(body) {
+		return json.Marshal(string(body))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(body)})
+}
+
+func (body *Body) UnmarshalJSON(data []byte) error {
+	text := ""
+	if err := json.Unmarshal(data, &text); err == nil {
+		*body = Body(text)
+		return nil
+	}
+	encoded := map[string]string{}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded["base64"])
+	*body = decoded
+	return err
+}
+
+// secretParams are redacted from URLs and form bodies, secretHeaders from
+// requests and responses.
+var (
+	secretParams  = []string{"access_token", "refresh_token", "code", "client_secret", "oauth_token", "oauth_token_secret", "oauth_signature"}
+	secretHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+	secretJson = regexp.MustCompile(`("(?:` + strings.Join(secretParams, "|") + `)"\s*:\s*)"[^"]*"`)
+	secretForm = regexp.MustCompile(`((?:^|&)(?:` + strings.Join(secretParams, "|") + `)=)[^&]*`)
+)
+
+// Recorder is a RoundTripper recording requests and their responses to a
+// cassette file, or replaying them from it. Replayed requests are matched by
+// method, path and query, in the order they were recorded, each at most
+// once. The bearer token, secret parameters and the strings in Redact never
+// reach the file.
+type Recorder struct {
+	Path string
+	Mode Mode
+	// Base sends requests while recording, http.DefaultTransport when nil.
+	Base http.RoundTripper
+	// Redact lists more secrets, like an app secret in a request body.
+	Redact []string
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool // by replay, for each interaction
+}
+
+// NewRecorder opens the cassette at path, which must exist to replay.
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	recorder := &Recorder{Path: path, Mode: mode}
+	if mode == ModeRecord {
+		return recorder, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && mode == ModeAuto {
+		recorder.Mode = ModeRecord
+		return recorder, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cassette := &Cassette{}
+	if err = json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("dropboxtest: cassette %s: %s", path, err)
+	}
+	recorder.Mode = ModeReplay
+	recorder.interactions = cassette.Interactions
+	recorder.used = make([]bool, len(cassette.Interactions))
+	return recorder, nil
+}
+
+// Client returns an http.Client for DropboxApi going through the recorder.
+func (recorder *Recorder) Client() *http.Client {
+	return &http.Client{Transport: recorder}
+}
+
+func (recorder *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	if recorder.Mode == ModeReplay {
+		return recorder.replay(req)
+	}
+	return recorder.record(req, body)
+}
+
+func (recorder *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	sent := req.Clone(req.Context())
+	sent.Body = ioutil.NopCloser(bytes.NewReader(body))
+	base := recorder.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(sent)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    recorder.redact(redactUrl(req.URL)),
+			Header: recorder.redactHeader(req.Header),
+			Body:   Body(recorder.redact(string(body))),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     recorder.redactHeader(resp.Header),
+			Body:       Body(recorder.redact(string(data))),
+		},
+	}
+
+	recorder.mu.Lock()
+	recorder.interactions = append(recorder.interactions, interaction)
+	recorder.mu.Unlock()
+	return resp, nil
+}
+
+func (recorder *Recorder) replay(req *http.Request) (*http.Response, error) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	want := recorder.matchKey(req.Method, req.URL)
+	for i, interaction := range recorder.interactions {
+		recorded, err := url.Parse(interaction.Request.URL)
+		if i >= len(recorder.used) || recorder.used[i] || err != nil || recorder.matchKey(interaction.Request.Method, recorded) != want {
+			continue
+		}
+		recorder.used[i] = true
+
+		answer := interaction.Response
+		resp := response(req, answer.StatusCode, string(answer.Body))
+		resp.Header = http.Header{}
+		for name, values := range answer.Header {
+			resp.Header[name] = append([]string{}, values...)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("dropboxtest: no interaction left in %s for %s", recorder.Path, want)
+}
+
+// matchKey is what replayed requests are matched on: the method, the path
+// and the query sorted by name, secrets redacted.
+func (recorder *Recorder) matchKey(method string, u *url.URL) string {
+	redacted, err := url.Parse(recorder.redact(redactUrl(u)))
+	if err != nil {
+		return method + " " + u.String()
+	}
+	key := method + " " + redacted.EscapedPath()
+	if query := redacted.Query(); len(query) > 0 {
+		for _, values := range query {
+			sort.Strings(values)
+		}
+		key += "?" + query.Encode()
+	}
+	return key
+}
+
+func redactUrl(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	for _, name := range secretParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, Redacted)
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+func (recorder *Recorder) redact(s string) string {
+	s = secretJson.ReplaceAllString(s, `$1"`+Redacted+`"`)
+	s = secretForm.ReplaceAllString(s, "${1}"+Redacted)
+	for _, secret := range recorder.Redact {
+		if len(secret) > 0 {
+			s = strings.Replace(s, secret, Redacted, -1)
+		}
+	}
+	return s
+}
+
+func (recorder *Recorder) redactHeader(header http.Header) http.Header {
+	redacted := http.Header{}
+	for name, values := range header {
+		for _, value := range values {
+			redacted.Add(name, recorder.redact(value))
+		}
+	}
+	for _, name := range secretHeaders {
+		if _, ok := redacted[name]; ok {
+			redacted.Set(name, Redacted)
+		}
+	}
+	return redacted
+}
+
+// Unused returns the interactions not replayed yet, a test that should have
+// replayed a whole cassette can check it is empty.
+func (recorder *Recorder) Unused() []*Interaction {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	unused := []*Interaction{}
+	for i, interaction := range recorder.interactions {
+		if i < len(recorder.used) && !recorder.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// Save writes the recorded cassette, it does nothing when replaying.
+func (recorder *Recorder) Save() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	if recorder.Mode == ModeReplay {
+		return nil
+	}
+	if len(recorder.Path) == 0 {
+		return errors.New("dropboxtest: the recorder has no Path")
+	}
+	data, err := json.MarshalIndent(&Cassette{Interactions: recorder.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(recorder.Path, append(data, '\n'), 0644)
+}
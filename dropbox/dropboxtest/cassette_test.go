@@ -0,0 +1,85 @@
+package dropboxtest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+func TestRecorderRedact(t *testing.T) {
+	recorder := &Recorder{Redact: []string{"app-secret"}}
+	cases := map[string]string{
+		`{"access_token": "abc", "token_type": "bearer"}`: `{"access_token": "REDACTED", "token_type": "bearer"}`,
+		"oauth_token_secret=x&oauth_token=y&uid=1":        "oauth_token_secret=REDACTED&oauth_token=REDACTED&uid=1",
+		"client_id=key&client_secret=app-secret":          "client_id=key&client_secret=REDACTED",
+		"hello app-secret":                                "hello REDACTED",
+	}
+	for s, want := range cases {
+		if redacted := recorder.redact(s); redacted != want {
+			t.Errorf("redact(%q) = %q, want %q", s, redacted, want)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	server := NewServer()
+	server.AccessToken = "secret-token"
+
+	calls := func(api *dropbox.DropboxApi) []string {
+		results := []string{}
+		if metadata, err := api.PutFile(strings.NewReader("hello\x00\xff"), "dropbox", "/a b.txt", "", true); err == nil {
+			results = append(results, metadata.Rev)
+		}
+		if metadata, err := api.GetFileMetadata_("dropbox", "/", 100, "", true, false, ""); err == nil {
+			results = append(results, metadata.Hash)
+		}
+		if file, err := api.GetFile("/a b.txt"); err == nil {
+			results = append(results, string(file.DataByte))
+		}
+		_, err := api.GetFile("/missing.txt")
+		return append(results, err.Error())
+	}
+
+	recorder, err := NewRecorder(cassette, ModeAuto)
+	if err != nil || recorder.Mode != ModeRecord {
+		t.Fatalf("NewRecorder without a cassette returned mode %d, %v", recorder.Mode, err)
+	}
+	recorder.Base = server.Client().Transport
+	api := server.Api()
+	api.Client = recorder.Client()
+	recorded := calls(api)
+	server.Close()
+	if len(recorded) != 4 || recorded[2] != "hello\x00\xff" {
+		t.Fatalf("recorded %q", recorded)
+	}
+	if err = recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(cassette)
+	if strings.Contains(string(data), "secret-token") || !strings.Contains(string(data), Redacted) {
+		t.Errorf("the cassette leaks the token:\n%s", data)
+	}
+
+	recorder, err = NewRecorder(cassette, ModeAuto)
+	if err != nil || recorder.Mode != ModeReplay {
+		t.Fatalf("NewRecorder with a cassette returned mode %d, %v", recorder.Mode, err)
+	}
+	api = &dropbox.DropboxApi{Signer: &dropbox.OAuth2{AccessToken: "other-token"}, Root: "dropbox",
+		ApiHost: "unused.invalid", ContentHost: "unused.invalid", Client: recorder.Client()}
+	if replayed := calls(api); strings.Join(replayed, "|") != strings.Join(recorded, "|") {
+		t.Errorf("replayed %q, recorded %q", replayed, recorded)
+	}
+	if unused := recorder.Unused(); len(unused) != 0 {
+		t.Errorf("%d interactions were not replayed", len(unused))
+	}
+
+	_, apiErr := api.GetFileMetadata("/")
+	if apiErr == nil || !strings.Contains(apiErr.Error(), "no interaction left") || !strings.Contains(apiErr.Error(), "GET /1/metadata/dropbox/%2F") {
+		t.Errorf("an unmatched request returned %v", apiErr)
+	}
+}
@@ -0,0 +1,219 @@
+package dropboxtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Fault is a failure a FaultTransport injects.
+type Fault int
+
+const (
+	// FaultLatency delays the request by the Latency of its rule, then sends
+	// it on. It adds up with the other faults.
+	FaultLatency Fault = iota
+	// FaultReset fails the request with a connection reset, it never reaches
+	// the server.
+	FaultReset
+	// FaultTruncate sends the request on and cuts the body of the response in
+	// half, reading the rest fails with io.ErrUnexpectedEOF.
+	FaultTruncate
+	// FaultTooManyRequests answers 429 without sending the request.
+	FaultTooManyRequests
+	// FaultServerError answers 500 without sending the request.
+	FaultServerError
+	// FaultUnavailable answers 503 without sending the request.
+	FaultUnavailable
+	// FaultWrongOffset sends a chunked_upload on and answers 400 with the
+	// offset the server is at, the way Dropbox rejects a chunk at a wrong
+	// offset. The chunk is stored all the same, like when the response to it
+	// was lost. Other requests are sent on untouched.
+	FaultWrongOffset
+)
+
+var faultNames = []string{"latency", "reset", "truncate", "429", "500", "503", "wrong offset"}
+
+func (fault Fault) String() string {
+	if fault < 0 || int(fault) >= len(faultNames) {
+		return fmt.Sprintf("Fault(%d)", int(fault))
+	}
+	return faultNames[fault]
+}
+
+// FaultRule injects a fault in the requests it matches, at a rate or on
+// given calls.
+type FaultRule struct {
+	Fault Fault
+	// Path limits the rule to requests whose path contains it, like
+	// "/chunked_upload", all requests match when it is empty.
+	Path string
+	// Rate is the chance of the fault for every matching request, 1 always.
+	Rate float64
+	// Calls numbers the matching requests, from 1, that get the fault.
+	Calls []int
+	// Latency is the delay of FaultLatency.
+	Latency time.Duration
+}
+
+// FaultTransport is a RoundTripper injecting faults in the requests going
+// through it to Base, to test how code copes with a flaky network and
+// server. Use it with the fake server,
+//
+//	transport := &dropboxtest.FaultTransport{Base: server.Client().Transport}
+//	transport.Rules = []dropboxtest.FaultRule{{Fault: dropboxtest.FaultServerError, Path: "/chunked_upload", Calls: []int{2}}}
+//	api := server.Api()
+//	api.Client = &http.Client{Transport: transport}
+//
+// Rules are tried in order, the first one firing other than FaultLatency
+// decides the fault of a request. Rates draw from a source seeded with Seed,
+// so a run can be repeated.
+type FaultTransport struct {
+	// Base sends the requests, http.DefaultTransport when nil.
+	Base  http.RoundTripper
+	Rules []FaultRule
+	Seed  int64
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	calls    map[int]int // of the rules, by index
+	injected map[Fault]int
+}
+
+// Injected returns how many times fault was injected.
+func (transport *FaultTransport) Injected(fault Fault) int {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	return transport.injected[fault]
+}
+
+// faults decides the faults of a request, the delay to add first and the
+// fault to inject, -1 for none.
+func (transport *FaultTransport) faults(req *http.Request) (time.Duration, Fault) {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	if transport.rand == nil {
+		transport.rand = rand.New(rand.NewSource(transport.Seed))
+		transport.calls = map[int]int{}
+		transport.injected = map[Fault]int{}
+	}
+
+	delay, fault := time.Duration(0), Fault(-1)
+	for i, rule := range transport.Rules {
+		if !strings.Contains(req.URL.Path, rule.Path) {
+			continue
+		}
+		transport.calls[i]++
+		if fault >= 0 || !rule.fires(transport.calls[i], transport.rand) {
+			continue
+		}
+		if rule.Fault == FaultWrongOffset && !strings.HasSuffix(req.URL.Path, "/chunked_upload") {
+			continue
+		}
+
+		transport.injected[rule.Fault]++
+		if rule.Fault == FaultLatency {
+			delay += rule.Latency
+		} else {
+			fault = rule.Fault
+		}
+	}
+	return delay, fault
+}
+
+func (rule *FaultRule) fires(call int, random *rand.Rand) bool {
+	for _, n := range rule.Calls {
+		if n == call {
+			return true
+		}
+	}
+	return rule.Rate > 0 && random.Float64() < rule.Rate
+}
+
+func (transport *FaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay, fault := transport.faults(req)
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			closeBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+
+	switch fault {
+	case FaultReset:
+		closeBody(req)
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	case FaultTooManyRequests, FaultServerError, FaultUnavailable:
+		closeBody(req)
+		code := map[Fault]int{FaultTooManyRequests: http.StatusTooManyRequests, FaultServerError: http.StatusInternalServerError,
+			FaultUnavailable: http.StatusServiceUnavailable}[fault]
+		return response(req, code, fmt.Sprintf(`{"error": "%s (injected)"}`, http.StatusText(code))), nil
+	}
+
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil || fault < 0 {
+		return resp, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	switch fault {
+	case FaultTruncate:
+		resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(data[:len(data)/2]), errorReader{io.ErrUnexpectedEOF}))
+	case FaultWrongOffset:
+		if resp.StatusCode == http.StatusOK {
+			resp.StatusCode, resp.Status = http.StatusBadRequest, "400 Bad Request"
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	}
+	return resp, nil
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func response(req *http.Request, code int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,107 @@
+package dropboxtest
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func faultyClient(server *Server, rules ...FaultRule) (*FaultTransport, *http.Client) {
+	transport := &FaultTransport{Base: server.Client().Transport, Rules: rules}
+	return transport, &http.Client{Transport: transport}
+}
+
+func TestFaultsRetriedUpload(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	data := bytes.Repeat([]byte("0123456789"), 10)
+
+	for _, fault := range []Fault{FaultReset, FaultTooManyRequests, FaultServerError, FaultUnavailable} {
+		transport, client := faultyClient(server, FaultRule{Fault: fault, Path: "/chunked_upload", Calls: []int{2}})
+		api := server.Api()
+		api.Client = client
+		metadata, err := api.UploadReaderByChunked(bytes.NewReader(data), "/up.bin", 30, 2)
+		if err != nil || metadata.Bytes != len(data) {
+			t.Errorf("upload with a %s fault returned %+v, %v", fault, metadata, err)
+		}
+		if n := transport.Injected(fault); n != 1 {
+			t.Errorf("%s was injected %d times", fault, n)
+		}
+
+		transport, client = faultyClient(server, FaultRule{Fault: fault, Path: "/chunked_upload", Rate: 1})
+		api.Client = client
+		if _, err = api.UploadReaderByChunked(bytes.NewReader(data), "/up.bin", 30, 3); err == nil {
+			t.Errorf("upload always failing with %s succeeded", fault)
+		}
+		if n := transport.Injected(fault); n != 3 {
+			t.Errorf("%s was injected %d times out of 3 tries", fault, n)
+		}
+	}
+}
+
+func TestFaultWrongOffset(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := server.Api()
+	transport, client := faultyClient(server, FaultRule{Fault: FaultWrongOffset, Calls: []int{2}})
+	api.Client = client
+
+	res, err := api.ChunkedUpload(strings.NewReader("abc"), 3, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.ChunkedUpload(strings.NewReader("def"), 3, res.Upload_id, 3); err == nil || err.Code != http.StatusBadRequest {
+		t.Errorf("chunk with a wrong offset fault returned %v", err)
+	}
+	if res, err = api.ChunkedUpload(strings.NewReader("ghi"), 3, res.Upload_id, 6); err != nil || res.Offset != 9 {
+		t.Errorf("the rejected chunk was not stored, got %+v, %v", res, err)
+	}
+	if _, err = api.GetFileMetadata("/"); err != nil || transport.Injected(FaultWrongOffset) != 1 {
+		t.Errorf("wrong offset fault touched another call: %v", err)
+	}
+}
+
+func TestFaultTruncate(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := server.Api()
+	_, api.Client = faultyClient(server, FaultRule{Fault: FaultTruncate, Path: "/files_put", Rate: 1})
+
+	if _, err := api.PutFile(strings.NewReader("hello"), "dropbox", "/a.txt", "", true); err == nil {
+		t.Error("PutFile with a truncated response succeeded")
+	}
+	if data, ok := server.ReadFile("/a.txt"); !ok || string(data) != "hello" {
+		t.Errorf("the upload with a truncated response stored %q", data)
+	}
+}
+
+func TestFaultRatesAndLatency(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	transport, client := faultyClient(server,
+		FaultRule{Fault: FaultLatency, Path: "/metadata", Calls: []int{1}, Latency: 20 * time.Millisecond},
+		FaultRule{Fault: FaultUnavailable, Path: "/account", Rate: 0.5})
+	transport.Seed = 7
+	api := server.Api()
+	api.Client = client
+
+	start := time.Now()
+	if _, err := api.GetFileMetadata("/"); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("delayed call returned after %s, %v", time.Since(start), err)
+	}
+
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if _, err := api.GetAccountInfo(); err != nil {
+			if err.Code != http.StatusServiceUnavailable {
+				t.Fatalf("GetAccountInfo returned %v", err)
+			}
+			failed++
+		}
+	}
+	if failed < 30 || failed > 70 || failed != transport.Injected(FaultUnavailable) {
+		t.Errorf("%d calls out of 100 failed at a rate of 0.5, %d injected", failed, transport.Injected(FaultUnavailable))
+	}
+}
@@ -0,0 +1,50 @@
+package dropboxtest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+// backup copies path next to itself, it needs only some of the calls.
+func backup(api interface {
+	dropbox.MetadataApi
+	dropbox.FileopsApi
+}, path string) (*dropbox.PathMetadata, *dropbox.ApiError) {
+	metadata, err := api.GetFileMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	return api.Copy(path, metadata.Path+".bak")
+}
+
+func TestMock(t *testing.T) {
+	mock := &Mock{}
+	mock.GetFileMetadataFunc = func(path string) (*dropbox.PathMetadata, *dropbox.ApiError) {
+		return &dropbox.PathMetadata{Content: dropbox.Content{Path: "/Notes.txt"}}, nil
+	}
+	mock.CopyFunc = func(from_path, to_path string) (*dropbox.PathMetadata, *dropbox.ApiError) {
+		return &dropbox.PathMetadata{Content: dropbox.Content{Path: to_path}}, nil
+	}
+
+	metadata, err := backup(mock, "/notes.txt")
+	if err != nil || metadata.Path != "/Notes.txt.bak" {
+		t.Errorf("backup returned %+v, %v", metadata, err)
+	}
+	calls := mock.Calls()
+	if len(calls) != 2 || calls[1].String() != "Copy[/notes.txt /Notes.txt.bak]" {
+		t.Errorf("recorded %v", calls)
+	}
+
+	if err = mock.RevokeToken(); err == nil || err.Code != http.StatusNotImplemented {
+		t.Errorf("a call without a function returned %v", err)
+	}
+	if calls = mock.Calls("RevokeToken", "Copy"); len(calls) != 2 || calls[0].Method != "Copy" {
+		t.Errorf("calls to RevokeToken and Copy are %v", calls)
+	}
+	mock.Reset()
+	if calls = mock.Calls(); len(calls) != 0 {
+		t.Errorf("Reset left %v", calls)
+	}
+}
@@ -0,0 +1,64 @@
+package dropboxtest
+
+//go:generate go run gen_mock.go
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+// Call is a call to a Mock.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+func (call Call) String() string {
+	return fmt.Sprintf("%s%v", call.Method, call.Args)
+}
+
+type callLog struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (log *callLog) record(method string, args ...interface{}) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.calls = append(log.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the calls made so far, in order. With method names, only
+// the calls to those.
+func (log *callLog) Calls(methods ...string) []Call {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	calls := []Call{}
+	for _, call := range log.calls {
+		if len(methods) == 0 {
+			calls = append(calls, call)
+		}
+		for _, method := range methods {
+			if call.Method == method {
+				calls = append(calls, call)
+			}
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls made so far.
+func (log *callLog) Reset() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.calls = nil
+}
+
+// unexpected is the error of a call to a Mock without a function for it.
+func unexpected(method string) *dropbox.ApiError {
+	return &dropbox.ApiError{ErrorMsg: fmt.Sprintf("dropboxtest: unexpected call to Mock.%s", method), Code: http.StatusNotImplemented}
+}
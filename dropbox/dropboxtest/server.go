@@ -0,0 +1,679 @@
+// Package dropboxtest runs an in-memory fake of the Dropbox v1 API, so code
+// using the dropbox package can be tested without an account or a network.
+//
+//	server := dropboxtest.NewServer()
+//	defer server.Close()
+//	server.WriteFile("/notes/a.txt", []byte("hello"))
+//	api := server.Api()
+//
+// Every root shares the same tree. Files keep their revisions, deletes are
+// remembered for include_deleted, revisions and restore, and every change is
+// logged for delta.
+package dropboxtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+// Server is a fake Dropbox listening on a local port.
+type Server struct {
+	*httptest.Server
+
+	// AccessToken is the bearer token requests must be signed with, any
+	// token is accepted when it is empty.
+	AccessToken string
+	// Account is returned by account/info, the normal quota used is
+	// counted from the tree.
+	Account dropbox.AccountInfo
+	// Now stamps changes, time.Now when nil.
+	Now func() time.Time
+	// DeltaPageSize caps the entries of a delta page, the others follow in
+	// pages with has_more set. No cap when 0.
+	DeltaPageSize int
+
+	mu      sync.Mutex
+	entries map[string]*entry // by lower-cased path
+	changes []string          // keys in the order they changed, for delta
+	uploads map[string][]byte
+	refs    map[string]string // copy refs to keys
+	links   map[string]string // media links to keys
+	tokens  int
+	revoked bool
+}
+
+// NewServer starts a server with an empty tree, Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Account: dropbox.AccountInfo{
+			Display_name: "Test User",
+			Email:        "test@example.com",
+			Uid:          1,
+			Country:      "US",
+			Quota_info:   dropbox.QuotaInfo{Quota: 2 << 30},
+		},
+		entries: map[string]*entry{"/": {path: "/", isDir: true}},
+		uploads: map[string][]byte{},
+		refs:    map[string]string{},
+		links:   map[string]string{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Api returns a DropboxApi whose requests all go to the server.
+func (s *Server) Api() *dropbox.DropboxApi {
+	token := s.AccessToken
+	if len(token) == 0 {
+		token = "dropboxtest"
+	}
+	return &dropbox.DropboxApi{
+		Signer:      &dropbox.OAuth2{AccessToken: token},
+		Root:        "dropbox",
+		Client:      s.Client(),
+		ApiHost:     s.URL,
+		ContentHost: s.URL,
+	}
+}
+
+// WriteFile stores a new revision of the file p, creating its folders, and
+// returns the rev.
+func (s *Server) WriteFile(p string, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.write(cleanPath(p), data)
+	if e == nil {
+		return "", fmt.Errorf("dropboxtest: a file is in the way of %s", p)
+	}
+	return e.latest().rev, nil
+}
+
+// Mkdir creates the folder p and its parents.
+func (s *Server) Mkdir(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mkdirAll(cleanPath(p)) == nil {
+		return fmt.Errorf("dropboxtest: a file is in the way of %s", p)
+	}
+	return nil
+}
+
+// ReadFile returns the latest content of the file p.
+func (s *Server) ReadFile(p string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.lookup(cleanPath(p))
+	if e == nil || e.isDir {
+		return nil, false
+	}
+	return append([]byte{}, e.latest().data...), true
+}
+
+func fail(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeJson(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// calls with a root and a path after their name, the rest are matched whole.
+var rootPathCalls = map[string]bool{
+	"metadata": true, "files": true, "files_put": true, "revisions": true, "restore": true, "search": true,
+	"shares": true, "media": true, "copy_ref": true, "thumbnails": true, "commit_chunked_upload": true,
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/media/") {
+		s.serveMedia(w, r)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if s.revoked || !strings.HasPrefix(auth, "Bearer ") || (len(s.AccessToken) > 0 && auth != "Bearer "+s.AccessToken) {
+		fail(w, http.StatusUnauthorized, "The given OAuth 2 access token doesn't exist or has expired.")
+		return
+	}
+
+	// the client query escapes root and path, slashes included
+	elems := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/1/"), "/", 3)
+	call, root, p := elems[0], "", "/"
+	if rootPathCalls[call] {
+		if len(elems) < 2 {
+			fail(w, http.StatusBadRequest, "Missing root")
+			return
+		}
+		root = elems[1]
+		if root != "dropbox" && root != "sandbox" && root != "auto" {
+			fail(w, http.StatusBadRequest, "Invalid root %q", root)
+			return
+		}
+		if len(elems) == 3 {
+			unescaped, err := url.QueryUnescape(elems[2])
+			if err != nil {
+				fail(w, http.StatusBadRequest, "Invalid path")
+				return
+			}
+			p = cleanPath(unescaped)
+		}
+	} else if len(elems) > 1 {
+		call += "/" + elems[1]
+		if len(elems) > 2 {
+			call += "/" + elems[2]
+		}
+	}
+
+	switch call {
+	case "account/info":
+		s.accountInfo(w, r)
+	case "disable_access_token":
+		s.revoked = true
+		writeJson(w, http.StatusOK, map[string]string{})
+	case "metadata":
+		s.getMetadata(w, r, root, p)
+	case "files", "thumbnails":
+		s.getFile(w, r, root, p, call == "thumbnails")
+	case "files_put":
+		s.putFile(w, r, root, p)
+	case "chunked_upload":
+		s.chunkedUpload(w, r)
+	case "commit_chunked_upload":
+		s.commitChunkedUpload(w, r, root, p)
+	case "delta":
+		s.delta(w, r)
+	case "revisions":
+		s.revisions(w, r, root, p)
+	case "restore":
+		s.restore(w, r, root, p)
+	case "search":
+		s.search(w, r, root, p)
+	case "shares", "media", "copy_ref":
+		s.link(w, r, call, root, p)
+	case "fileops/create_folder", "fileops/delete", "fileops/move", "fileops/copy":
+		s.fileops(w, r, strings.TrimPrefix(call, "fileops/"))
+	default:
+		fail(w, http.StatusNotFound, "Unknown call %s", r.URL.Path)
+	}
+}
+
+func (s *Server) accountInfo(w http.ResponseWriter, r *http.Request) {
+	normal := 0
+	for _, e := range s.entries {
+		if !e.deleted && !e.isDir {
+			normal += len(e.latest().data)
+		}
+	}
+
+	account := s.Account
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"referral_link": account.Referral_link,
+		"display_name":  account.Display_name,
+		"uid":           account.Uid,
+		"country":       account.Country,
+		"email":         account.Email,
+		"quota_info": map[string]int64{
+			"shared": account.Quota_info.Shared,
+			"quota":  account.Quota_info.Quota,
+			"normal": account.Quota_info.Normal + int64(normal),
+		},
+	})
+}
+
+func boolValue(r *http.Request, name string, value bool) bool {
+	if v, err := strconv.ParseBool(r.FormValue(name)); err == nil {
+		return v
+	}
+	return value
+}
+
+func intValue(r *http.Request, name string, value int) int {
+	if v, err := strconv.Atoi(r.FormValue(name)); err == nil {
+		return v
+	}
+	return value
+}
+
+// find returns the entry at p and the revision rev of it, the latest one
+// when rev is empty. It answers the request itself when there is none.
+func (s *Server) find(w http.ResponseWriter, p, rev string, deleted bool) (*entry, *revision) {
+	e := s.entries[key(p)]
+	if e == nil || (e.deleted && !deleted) {
+		fail(w, http.StatusNotFound, "Path '%s' not found", p)
+		return nil, nil
+	}
+	if len(rev) == 0 || e.isDir {
+		return e, e.latest()
+	}
+	for _, r := range e.revs {
+		if r.rev == rev {
+			return e, r
+		}
+	}
+	fail(w, http.StatusNotFound, "Unable to find revision %s of %s", rev, p)
+	return nil, nil
+}
+
+func (s *Server) getMetadata(w http.ResponseWriter, r *http.Request, root, p string) {
+	deleted := boolValue(r, "include_deleted", false)
+	e, rev := s.find(w, p, r.FormValue("rev"), deleted)
+	if e == nil {
+		return
+	}
+
+	m := s.metadata(e, rev, root)
+	if e.isDir && boolValue(r, "list", true) {
+		m.Hash = s.hash(p)
+		if m.Hash == r.FormValue("hash") {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		children := s.children(p, deleted)
+		if len(children) > intValue(r, "file_limit", 10000) {
+			fail(w, http.StatusNotAcceptable, "Too many file entries to return")
+			return
+		}
+		m.Contents = []*metadata{}
+		for _, child := range children {
+			m.Contents = append(m.Contents, s.metadata(child, nil, root))
+		}
+	}
+	writeJson(w, http.StatusOK, m)
+}
+
+func (s *Server) getFile(w http.ResponseWriter, r *http.Request, root, p string, thumbnail bool) {
+	e, rev := s.find(w, p, r.FormValue("rev"), false)
+	if e == nil {
+		return
+	}
+	if e.isDir || rev.deleted {
+		fail(w, http.StatusNotFound, "Path '%s' is not a file", p)
+		return
+	}
+	if thumbnail && !isImage(e.path) {
+		fail(w, http.StatusUnsupportedMediaType, "Image is invalid and cannot be converted to a thumbnail")
+		return
+	}
+
+	header, _ := json.Marshal(s.metadata(e, rev, root))
+	w.Header().Set("x-dropbox-metadata", string(header))
+	w.Header().Set("Content-Type", s.metadata(e, rev, root).Mime_type)
+
+	data := rev.data
+	if rangeHeader := r.Header.Get("Range"); len(rangeHeader) > 0 && !thumbnail {
+		start, n, err := dropbox.ParseRange(rangeHeader, int64(len(data)))
+		switch err {
+		case dropbox.ErrRangeUnsatisfiable:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			fail(w, http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable")
+			return
+		case nil:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, len(data)))
+			w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : start+n])
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+func (s *Server) putFile(w http.ResponseWriter, r *http.Request, root, p string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	s.stored(w, root, p, s.put(p, data, boolValue(r, "overwrite", true), r.FormValue("parent_rev")))
+}
+
+func (s *Server) stored(w http.ResponseWriter, root, p string, e *entry) {
+	if e == nil {
+		fail(w, http.StatusForbidden, "A file is in the way of %s", p)
+		return
+	}
+	writeJson(w, http.StatusOK, s.metadata(e, nil, root))
+}
+
+func (s *Server) chunkedUpload(w http.ResponseWriter, r *http.Request) {
+	id, offset := r.FormValue("upload_id"), intValue(r, "offset", 0)
+	if len(id) == 0 {
+		s.tokens++
+		id = fmt.Sprintf("upload-%d", s.tokens)
+		s.uploads[id] = []byte{}
+	}
+	data, ok := s.uploads[id]
+	if !ok {
+		fail(w, http.StatusNotFound, "Upload %s not found", id)
+		return
+	}
+
+	expires := s.now().Add(24 * time.Hour).Format(time.RFC1123Z)
+	if offset != len(data) {
+		writeJson(w, http.StatusBadRequest, map[string]interface{}{"upload_id": id, "offset": len(data), "expires": expires})
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	s.uploads[id] = append(data, body...)
+	writeJson(w, http.StatusOK, map[string]interface{}{"upload_id": id, "offset": len(s.uploads[id]), "expires": expires})
+}
+
+func (s *Server) commitChunkedUpload(w http.ResponseWriter, r *http.Request, root, p string) {
+	id := r.FormValue("upload_id")
+	data, ok := s.uploads[id]
+	if !ok {
+		fail(w, http.StatusBadRequest, "Invalid upload_id %q", id)
+		return
+	}
+	delete(s.uploads, id)
+	s.stored(w, root, p, s.put(p, data, boolValue(r, "overwrite", true), r.FormValue("parent_rev")))
+}
+
+func (s *Server) delta(w http.ResponseWriter, r *http.Request) {
+	// a cursor is the number of changes seen, or since:upTo:offset within
+	// the pages of a delta, since is -1 for the pages of a reset.
+	cursor := r.FormValue("cursor")
+	reset := len(cursor) == 0
+	since, upTo, offset := -1, len(s.changes), 0
+	if !reset {
+		var ok bool
+		if since, upTo, offset, ok = s.parseCursor(cursor); !ok {
+			fail(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	keys := s.deltaKeys(since, upTo)
+	if offset > len(keys) {
+		fail(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	keys = keys[offset:]
+	cursor, hasMore := strconv.Itoa(upTo), false
+	if s.DeltaPageSize > 0 && len(keys) > s.DeltaPageSize {
+		keys = keys[:s.DeltaPageSize]
+		cursor, hasMore = fmt.Sprintf("%d:%d:%d", since, upTo, offset+len(keys)), true
+	}
+
+	entries := [][]interface{}{}
+	for _, k := range keys {
+		var m interface{}
+		if e := s.lookup(k); e != nil {
+			m = s.metadata(e, nil, "dropbox")
+		} else if since < 0 {
+			// a listing leaves out what was deleted meanwhile
+			continue
+		}
+		entries = append(entries, []interface{}{k, m})
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"entries":  entries,
+		"reset":    reset,
+		"cursor":   cursor,
+		"has_more": hasMore,
+	})
+}
+
+func (s *Server) parseCursor(cursor string) (since, upTo, offset int, ok bool) {
+	parts := strings.Split(cursor, ":")
+	if len(parts) == 1 {
+		n, err := strconv.Atoi(cursor)
+		return n, len(s.changes), 0, err == nil && n >= 0 && n <= len(s.changes)
+	}
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	n := make([]int, 3)
+	for i, part := range parts {
+		var err error
+		if n[i], err = strconv.Atoi(part); err != nil {
+			return 0, 0, 0, false
+		}
+	}
+	since, upTo, offset = n[0], n[1], n[2]
+	return since, upTo, offset, since >= -1 && since <= upTo && upTo <= len(s.changes) && offset >= 0
+}
+
+// deltaKeys returns the paths changed in changes[since:upTo], in the order
+// of their latest change, or every path changed before upTo, sorted, for a
+// listing when since is -1. The pages of a delta share them.
+func (s *Server) deltaKeys(since, upTo int) []string {
+	keys := []string{}
+	if since < 0 {
+		seen := map[string]bool{"/": true}
+		for _, k := range s.changes[:upTo] {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	// the latest change of every path, in the order they happened
+	last := map[string]int{}
+	for i, k := range s.changes[since:upTo] {
+		last[k] = i
+	}
+	for i, k := range s.changes[since:upTo] {
+		if last[k] == i && k != "/" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (s *Server) revisions(w http.ResponseWriter, r *http.Request, root, p string) {
+	e, _ := s.find(w, p, "", true)
+	if e == nil {
+		return
+	}
+	if e.isDir {
+		fail(w, http.StatusNotAcceptable, "Path '%s' is a folder", p)
+		return
+	}
+
+	limit := intValue(r, "rev_limit", 10)
+	if limit > 1000 {
+		limit = 1000
+	}
+	list := []*metadata{}
+	for i := len(e.revs) - 1; i >= 0 && len(list) < limit; i-- {
+		list = append(list, s.metadata(e, e.revs[i], root))
+	}
+	writeJson(w, http.StatusOK, list)
+}
+
+func (s *Server) restore(w http.ResponseWriter, r *http.Request, root, p string) {
+	e, rev := s.find(w, p, r.FormValue("rev"), true)
+	if e == nil {
+		return
+	}
+	if e.isDir || len(r.FormValue("rev")) == 0 || rev.deleted {
+		fail(w, http.StatusBadRequest, "Cannot restore %s to rev %q", p, r.FormValue("rev"))
+		return
+	}
+	s.stored(w, root, p, s.write(e.path, rev.data))
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request, root, p string) {
+	query := strings.Fields(strings.ToLower(r.FormValue("query")))
+	if len(query) == 0 {
+		fail(w, http.StatusBadRequest, "Must provide a query")
+		return
+	}
+	if e, _ := s.find(w, p, "", false); e == nil {
+		return
+	}
+
+	deleted, limit := boolValue(r, "include_deleted", false), intValue(r, "file_limit", 1000)
+	prefix := key(p)
+	if prefix != "/" {
+		prefix += "/"
+	}
+	keys := []string{}
+	for k, e := range s.entries {
+		if k == "/" || !strings.HasPrefix(k, prefix) || (e.deleted && !deleted) {
+			continue
+		}
+		name, match := path.Base(k), true
+		for _, term := range query {
+			match = match && strings.Contains(name, term)
+		}
+		if match {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	list := []*metadata{}
+	for _, k := range keys {
+		if len(list) == limit {
+			break
+		}
+		list = append(list, s.metadata(s.entries[k], nil, root))
+	}
+	writeJson(w, http.StatusOK, list)
+}
+
+// link answers shares, media and copy_ref. Media links are served by the
+// server, copy refs can be copied from with fileops/copy.
+func (s *Server) link(w http.ResponseWriter, r *http.Request, call, root, p string) {
+	e, _ := s.find(w, p, "", false)
+	if e == nil {
+		return
+	}
+	s.tokens++
+	token := fmt.Sprintf("%015x", s.tokens*7919+namespace)
+	expires := s.now().Add(4 * time.Hour).Format(time.RFC1123Z)
+
+	switch call {
+	case "shares":
+		link := "https://db.tt/" + token[:8]
+		if !boolValue(r, "short_url", true) {
+			link = "https://www.dropbox.com/s/" + token + "/" + url.PathEscape(path.Base(e.path))
+		}
+		writeJson(w, http.StatusOK, map[string]string{"url": link, "expires": "Tue, 01 Jan 2030 00:00:00 +0000"})
+	case "media":
+		if e.isDir {
+			fail(w, http.StatusNotFound, "Path '%s' is not a file", p)
+			return
+		}
+		s.links[token] = key(p)
+		writeJson(w, http.StatusOK, map[string]string{"url": s.URL + "/media/" + token + "/" + url.PathEscape(path.Base(e.path)), "expires": expires})
+	case "copy_ref":
+		s.refs[token] = key(p)
+		writeJson(w, http.StatusOK, map[string]string{"copy_ref": token, "expires": "Tue, 01 Jan 2030 00:00:00 +0000"})
+	}
+}
+
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request) {
+	token := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/media/"), "/", 2)[0]
+	e := s.lookup(s.links[token])
+	if e == nil || e.isDir {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", s.metadata(e, nil, "dropbox").Mime_type)
+	w.Write(e.latest().data)
+}
+
+func (s *Server) fileops(w http.ResponseWriter, r *http.Request, op string) {
+	root := r.FormValue("root")
+	if root != "dropbox" && root != "sandbox" && root != "auto" {
+		fail(w, http.StatusBadRequest, "Invalid root %q", root)
+		return
+	}
+
+	switch op {
+	case "create_folder":
+		p := cleanPath(r.FormValue("path"))
+		if s.lookup(p) != nil {
+			fail(w, http.StatusForbidden, "At path '%s' there's already a folder or a file.", p)
+			return
+		}
+		s.stored(w, root, p, s.mkdirAll(p))
+		return
+	case "delete":
+		p := cleanPath(r.FormValue("path"))
+		e, _ := s.find(w, p, "", false)
+		if e == nil {
+			return
+		}
+		if p == "/" {
+			fail(w, http.StatusForbidden, "Cannot delete the root")
+			return
+		}
+		s.remove(e.path)
+		writeJson(w, http.StatusOK, s.metadata(e, nil, root))
+		return
+	}
+
+	from, to := cleanPath(r.FormValue("from_path")), cleanPath(r.FormValue("to_path"))
+	if ref := r.FormValue("from_copy_ref"); op == "copy" && len(r.FormValue("from_path")) == 0 {
+		k, ok := s.refs[ref]
+		if !ok || s.lookup(k) == nil {
+			fail(w, http.StatusNotFound, "Invalid copy ref %q", ref)
+			return
+		}
+		from = s.lookup(k).path
+	}
+
+	e, _ := s.find(w, from, "", false)
+	if e == nil {
+		return
+	}
+	if from == "/" || (strings.HasPrefix(key(to)+"/", key(e.path)+"/") && key(to) != key(e.path)) {
+		fail(w, http.StatusForbidden, "Cannot %s %s into itself", op, from)
+		return
+	}
+
+	if op == "move" && key(to) == key(e.path) {
+		// a change of case only
+		for _, child := range s.descendants(e.path) {
+			child.path = to + child.path[len(e.path):]
+			s.record(child.path)
+		}
+		writeJson(w, http.StatusOK, s.metadata(e, nil, root))
+		return
+	}
+	if s.lookup(to) != nil {
+		fail(w, http.StatusForbidden, "At path '%s' there's already a folder or a file.", to)
+		return
+	}
+	if s.mkdirAll(path.Dir(to)) == nil {
+		fail(w, http.StatusForbidden, "A file is in the way of %s", to)
+		return
+	}
+
+	s.copyTree(e.path, to)
+	if op == "move" {
+		s.remove(e.path)
+	}
+	writeJson(w, http.StatusOK, s.metadata(s.lookup(to), nil, root))
+}
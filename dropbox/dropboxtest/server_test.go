@@ -0,0 +1,253 @@
+package dropboxtest
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+func TestFiles(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := server.Api()
+
+	metadata, err := api.PutFile(strings.NewReader("hello"), "dropbox", "/Docs/A b+c.txt", "", true)
+	if err != nil || metadata.Path != "/Docs/A b+c.txt" || metadata.Bytes != 5 || metadata.Mime_type != "text/plain; charset=utf-8" {
+		t.Fatalf("PutFile returned %+v, %v", metadata, err)
+	}
+	first := metadata.Rev
+
+	file, err := api.GetFile("/docs/a b+c.txt")
+	if err != nil || string(file.DataByte) != "hello" || file.Rev != first {
+		t.Errorf("GetFile returned %q rev %s, %v", file.DataByte, file.Rev, err)
+	}
+	reader, err := api.GetFileRange_("dropbox", "/docs/a b+c.txt", "", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(reader); string(data) != "ell" {
+		t.Errorf("ranged read returned %q", data)
+	}
+	reader.Close()
+
+	// an upload against an old parent rev is renamed
+	api.PutFile(strings.NewReader("hello world"), "dropbox", "/Docs/A b+c.txt", first, false)
+	metadata, err = api.PutFile(strings.NewReader("conflict"), "dropbox", "/Docs/A b+c.txt", first, false)
+	if err != nil || metadata.Path != "/Docs/A b+c (1).txt" {
+		t.Errorf("conflicting upload returned %+v, %v", metadata, err)
+	}
+
+	listing, err := api.GetFileMetadata("/docs")
+	if err != nil || len(listing.Contents) != 2 || len(listing.Hash) == 0 {
+		t.Fatalf("listing returned %+v, %v", listing, err)
+	}
+	if _, err = api.GetFileMetadata_("dropbox", "/docs", 10000, listing.Hash, true, false, ""); err == nil || err.Code != http.StatusNotModified {
+		t.Errorf("listing with the same hash returned %v", err)
+	}
+	if _, err = api.GetFileMetadata_("dropbox", "/docs", 1, "", true, false, ""); err == nil || err.Code != http.StatusNotAcceptable {
+		t.Errorf("listing above file_limit returned %v", err)
+	}
+
+	revisions, err := api.Revisions("/docs/a b+c.txt")
+	if err != nil || len(*revisions) != 2 || (*revisions)[1].Rev != first {
+		t.Fatalf("Revisions returned %+v, %v", revisions, err)
+	}
+	if metadata, err = api.Restore("/docs/a b+c.txt", first); err != nil || metadata.Rev == first {
+		t.Errorf("Restore returned %+v, %v", metadata, err)
+	}
+	if data, _ := server.ReadFile("/Docs/A b+c.txt"); string(data) != "hello" {
+		t.Errorf("restored file holds %q", data)
+	}
+
+	if _, err = api.GetFile("/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetFile of a missing file returned %v", err)
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := server.Api()
+
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	metadata, err := api.UploadReaderByChunked(bytes.NewReader(data), "/big.bin", 64, 1)
+	if err != nil || metadata.Bytes != len(data) {
+		t.Fatalf("chunked upload returned %+v, %v", metadata, err)
+	}
+	if stored, _ := server.ReadFile("/big.bin"); !bytes.Equal(stored, data) {
+		t.Error("chunked upload stored other data")
+	}
+
+	res, err := api.ChunkedUpload(strings.NewReader("abc"), 3, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.ChunkedUpload(strings.NewReader("d"), 1, res.Upload_id, 1); err == nil || err.Code != http.StatusBadRequest {
+		t.Errorf("chunk at a wrong offset returned %v", err)
+	}
+	if _, err = api.CommitChunkedUpload_("dropbox", "/x", "unknown", "", true); err == nil {
+		t.Error("committing an unknown upload succeeded")
+	}
+}
+
+func TestFileops(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := server.Api()
+	server.WriteFile("/a/b/c.txt", []byte("c"))
+
+	if _, err := api.CreateFolder("/A"); err == nil || err.Code != http.StatusForbidden {
+		t.Errorf("creating an existing folder returned %v", err)
+	}
+	if _, err := api.Copy("/a", "/a/b/copy"); err == nil || err.Code != http.StatusForbidden {
+		t.Errorf("copying a folder into itself returned %v", err)
+	}
+	if metadata, err := api.Copy("/a", "/x"); err != nil || !metadata.Is_dir {
+		t.Errorf("Copy returned %+v, %v", metadata, err)
+	}
+	if metadata, err := api.Move("/a/b", "/B"); err != nil || metadata.Path != "/B" {
+		t.Errorf("Move returned %+v, %v", metadata, err)
+	}
+	if metadata, err := api.Move("/B", "/b"); err != nil || metadata.Path != "/b" {
+		t.Errorf("case only Move returned %+v, %v", metadata, err)
+	}
+
+	ref, err := api.CopyRef("/b/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.Copy_("dropbox", "", "/from-ref.txt", ref["copy_ref"]); err != nil {
+		t.Errorf("copy from a copy ref returned %v", err)
+	}
+
+	if metadata, err := api.Delete("/b"); err != nil || !metadata.Is_dir {
+		t.Errorf("Delete returned %+v, %v", metadata, err)
+	}
+	if _, ok := server.ReadFile("/b/c.txt"); ok {
+		t.Error("Delete left a file of the folder")
+	}
+	deleted, err := api.GetFileMetadata_("dropbox", "/b/c.txt", 1, "", false, true, "")
+	if err != nil || deleted.Bytes != 0 {
+		t.Errorf("deleted file returned %+v, %v", deleted, err)
+	}
+
+	for _, p := range []string{"/x/b/c.txt", "/from-ref.txt"} {
+		if data, ok := server.ReadFile(p); !ok || string(data) != "c" {
+			t.Errorf("%s holds %q", p, data)
+		}
+	}
+}
+
+func TestDeltaSearchAndLinks(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	api := server.Api()
+	server.WriteFile("/Photos/beach.jpg", []byte("jpeg"))
+
+	delta, err := api.Delta("")
+	if err != nil || !delta.Reset || len(delta.Entries) != 2 || delta.Entries[1].Metadata.Path != "/Photos/beach.jpg" {
+		t.Fatalf("first Delta returned %+v, %v", delta, err)
+	}
+
+	server.WriteFile("/photos/beach.jpg", []byte("new"))
+	api.Delete("/Photos/beach.jpg")
+	api.CreateFolder("/notes")
+	delta, err = api.Delta(delta.Cursor)
+	if err != nil || delta.Reset || len(delta.Entries) != 2 {
+		t.Fatalf("second Delta returned %+v, %v", delta, err)
+	}
+	if delta.Entries[0].Path != "/photos/beach.jpg" || delta.Entries[0].Metadata != nil || delta.Entries[1].Metadata == nil {
+		t.Errorf("second Delta returned %+v %+v", delta.Entries[0], delta.Entries[1])
+	}
+
+	server.WriteFile("/Photos/Beach Party.png", []byte("png"))
+	results, err := api.Search("/", "beach")
+	if err != nil || len(*results) != 1 {
+		t.Errorf("Search returned %+v, %v", results, err)
+	}
+	if results, _ = api.Search_("dropbox", "/", "beach", 10, true); len(*results) != 2 {
+		t.Errorf("Search with deleted files returned %+v", results)
+	}
+
+	if thumb, err := api.Thumbnails("/photos/beach party.png"); err != nil || string(thumb.DataByte) != "png" {
+		t.Errorf("Thumbnails returned %v", err)
+	}
+	if links, err := api.Shares("/photos"); err != nil || !strings.HasPrefix(links["url"], "https://db.tt/") {
+		t.Errorf("Shares returned %v, %v", links, err)
+	}
+
+	media, err := api.Media("/photos/beach party.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, httperr := http.Get(media["url"])
+	if httperr != nil {
+		t.Fatal(httperr)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "png" {
+		t.Errorf("media link served %q", data)
+	}
+}
+
+func TestDeltaPages(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.DeltaPageSize = 2
+	api := server.Api()
+	server.WriteFile("/a.txt", []byte("a"))
+	server.WriteFile("/b.txt", []byte("b"))
+	server.WriteFile("/c.txt", []byte("c"))
+
+	delta, err := api.Delta("")
+	if err != nil || !delta.Reset || !delta.HasMore || len(delta.Entries) != 2 || delta.Entries[0].Path != "/a.txt" {
+		t.Fatalf("first page returned %+v, %v", delta, err)
+	}
+
+	// a change between the pages waits for the next delta
+	server.WriteFile("/d.txt", []byte("d"))
+	delta, err = api.Delta(delta.Cursor)
+	if err != nil || delta.Reset || delta.HasMore || len(delta.Entries) != 1 || delta.Entries[0].Path != "/c.txt" {
+		t.Fatalf("second page returned %+v, %v", delta, err)
+	}
+	delta, err = api.Delta(delta.Cursor)
+	if err != nil || delta.HasMore || len(delta.Entries) != 1 || delta.Entries[0].Path != "/d.txt" {
+		t.Errorf("next delta returned %+v, %v", delta, err)
+	}
+
+	if _, err = api.Delta("0:1:5"); err == nil || err.Code != http.StatusBadRequest {
+		t.Errorf("a cursor past the changes returned %v", err)
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AccessToken = "secret"
+	server.WriteFile("/a.txt", []byte("12345"))
+
+	api := server.Api()
+	if info, err := api.GetAccountInfo(); err != nil || info.Quota_info.Normal != 5 || info.Display_name != "Test User" {
+		t.Errorf("GetAccountInfo returned %+v, %v", info, err)
+	}
+
+	wrong := server.Api()
+	wrong.Signer = &dropbox.OAuth2{AccessToken: "wrong"}
+	if _, err := wrong.GetAccountInfo(); err == nil || err.Code != http.StatusUnauthorized {
+		t.Errorf("a wrong token returned %v", err)
+	}
+
+	if err := api.RevokeToken(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.GetFileMetadata("/"); err == nil || err.Code != http.StatusUnauthorized {
+		t.Errorf("a revoked token returned %v", err)
+	}
+}
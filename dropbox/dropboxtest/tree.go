@@ -0,0 +1,281 @@
+package dropboxtest
+
+import (
+	"crypto/md5"
+	"fmt"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type revision struct {
+	rev      string
+	number   int
+	data     []byte
+	modified time.Time
+	deleted  bool
+}
+
+// entry is a file or folder, deleted ones are kept for their revisions.
+type entry struct {
+	path     string // as it was created, the key is lower-cased
+	isDir    bool
+	deleted  bool
+	modified time.Time
+	revs     []*revision // of a file, oldest first
+}
+
+// namespace ends every rev, like the id of the account does on Dropbox.
+const namespace = 0x0e16a59f
+
+func revString(number int) string {
+	return fmt.Sprintf("%x%08x", number, namespace)
+}
+
+func (e *entry) latest() *revision {
+	if len(e.revs) == 0 {
+		return nil
+	}
+	return e.revs[len(e.revs)-1]
+}
+
+// metadata is an entry in the JSON of the v1 API.
+type metadata struct {
+	Size         string      `json:"size"`
+	Rev          string      `json:"rev,omitempty"`
+	Thumb_exists bool        `json:"thumb_exists"`
+	Bytes        int         `json:"bytes"`
+	Modified     string      `json:"modified,omitempty"`
+	Client_mtime string      `json:"client_mtime,omitempty"`
+	Path         string      `json:"path"`
+	Is_dir       bool        `json:"is_dir"`
+	Is_deleted   bool        `json:"is_deleted,omitempty"`
+	Icon         string      `json:"icon"`
+	Root         string      `json:"root"`
+	Mime_type    string      `json:"mime_type,omitempty"`
+	Revision     int         `json:"revision,omitempty"`
+	Hash         string      `json:"hash,omitempty"`
+	Contents     []*metadata `json:"contents,omitempty"`
+}
+
+func key(p string) string {
+	return strings.ToLower(p)
+}
+
+// cleanPath turns the path of a request into "/a/b", "/" for the root.
+func cleanPath(p string) string {
+	return path.Clean("/" + strings.Trim(p, "/"))
+}
+
+func isImage(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".tif":
+		return true
+	}
+	return false
+}
+
+func humanSize(bytes int) string {
+	units := []string{"bytes", "KB", "MB", "GB", "TB"}
+	size, unit := float64(bytes), 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+// lookup returns the live entry at p.
+func (s *Server) lookup(p string) *entry {
+	if e := s.entries[key(p)]; e != nil && !e.deleted {
+		return e
+	}
+	return nil
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// record logs a change for delta, every change gets a new revision number.
+func (s *Server) record(p string) int {
+	s.changes = append(s.changes, key(p))
+	return len(s.changes)
+}
+
+// children returns the entries right below the folder p, sorted by key.
+func (s *Server) children(p string, deleted bool) []*entry {
+	prefix := key(p)
+	if prefix != "/" {
+		prefix += "/"
+	}
+	list := []*entry{}
+	for k, e := range s.entries {
+		if k == "/" || !strings.HasPrefix(k, prefix) || strings.Contains(k[len(prefix):], "/") {
+			continue
+		}
+		if !e.deleted || deleted {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return key(list[i].path) < key(list[j].path) })
+	return list
+}
+
+// descendants returns p and the live entries below it, parents first.
+func (s *Server) descendants(p string) []*entry {
+	prefix := key(p) + "/"
+	list := []*entry{}
+	for k, e := range s.entries {
+		if !e.deleted && (k == key(p) || strings.HasPrefix(k, prefix)) {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return key(list[i].path) < key(list[j].path) })
+	return list
+}
+
+// hash changes whenever a child of the folder does.
+func (s *Server) hash(p string) string {
+	h := md5.New()
+	for _, e := range s.children(p, false) {
+		rev := ""
+		if r := e.latest(); r != nil {
+			rev = r.rev
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%t\n", e.path, rev, e.isDir)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (s *Server) metadata(e *entry, r *revision, root string) *metadata {
+	m := &metadata{Path: e.path, Is_dir: e.isDir, Is_deleted: e.deleted, Root: "dropbox", Icon: "folder"}
+	if root == "sandbox" {
+		m.Root = "app_folder"
+	}
+	if e.isDir {
+		m.Size = humanSize(0)
+		if !e.modified.IsZero() {
+			m.Modified = e.modified.Format(time.RFC1123Z)
+		}
+		return m
+	}
+
+	if r == nil {
+		r = e.latest()
+	}
+	m.Icon = "page_white"
+	m.Rev, m.Revision, m.Bytes = r.rev, r.number, len(r.data)
+	m.Size = humanSize(m.Bytes)
+	m.Modified = r.modified.Format(time.RFC1123Z)
+	m.Client_mtime = m.Modified
+	m.Is_deleted = r.deleted
+	m.Thumb_exists = isImage(e.path)
+	m.Mime_type = mime.TypeByExtension(path.Ext(e.path))
+	if len(m.Mime_type) == 0 {
+		m.Mime_type = "application/octet-stream"
+	}
+	return m
+}
+
+// mkdirAll creates the folder p and its parents, it fails when a file is in
+// the way.
+func (s *Server) mkdirAll(p string) *entry {
+	if e := s.lookup(p); e != nil {
+		if e.isDir {
+			return e
+		}
+		return nil
+	}
+	if s.mkdirAll(path.Dir(p)) == nil {
+		return nil
+	}
+
+	e := &entry{path: p, isDir: true, modified: s.now()}
+	s.entries[key(p)] = e
+	s.record(p)
+	return e
+}
+
+// write adds a revision to the file p, creating it and its parents.
+func (s *Server) write(p string, data []byte) *entry {
+	if s.mkdirAll(path.Dir(p)) == nil {
+		return nil
+	}
+	e := s.entries[key(p)]
+	switch {
+	case e == nil || (e.deleted && e.isDir):
+		e = &entry{path: p}
+		s.entries[key(p)] = e
+	case e.isDir:
+		return nil
+	case e.deleted:
+		e.path, e.deleted = p, false
+	}
+
+	number := s.record(p)
+	e.modified = s.now()
+	e.revs = append(e.revs, &revision{rev: revString(number), number: number, data: append([]byte{}, data...), modified: e.modified})
+	return e
+}
+
+// put stores an upload the way files_put does, an upload that would
+// overwrite a file it should not is renamed to "name (1).ext".
+func (s *Server) put(p string, data []byte, overwrite bool, parentRev string) *entry {
+	if e := s.lookup(p); e != nil {
+		switch {
+		case e.isDir:
+			p = s.autorename(p)
+		case len(parentRev) > 0 && parentRev != e.latest().rev:
+			p = s.autorename(p)
+		case len(parentRev) == 0 && !overwrite:
+			p = s.autorename(p)
+		case string(e.latest().data) == string(data):
+			return e
+		}
+	}
+	return s.write(p, data)
+}
+
+func (s *Server) autorename(p string) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if s.lookup(candidate) == nil {
+			return candidate
+		}
+	}
+}
+
+// remove deletes p and everything below it, files get a deleted revision.
+func (s *Server) remove(p string) {
+	for _, e := range s.descendants(p) {
+		e.deleted = true
+		if !e.isDir {
+			number := len(s.changes) + 1
+			e.revs = append(e.revs, &revision{rev: revString(number), number: number, modified: s.now(), deleted: true})
+		}
+	}
+	s.record(p)
+}
+
+// copyTree copies from and everything below it to to.
+func (s *Server) copyTree(from, to string) {
+	for _, e := range s.descendants(from) {
+		target := to + e.path[len(from):]
+		if e.isDir {
+			s.mkdirAll(target)
+		} else {
+			s.write(target, e.latest().data)
+		}
+	}
+}
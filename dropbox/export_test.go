@@ -0,0 +1,14 @@
+package dropbox
+
+import "bufio"
+
+// Internals of the package for its external tests.
+
+type (
+	S3ListBucketResult              = s3ListBucketResult
+	S3InitiateMultipartUploadResult = s3InitiateMultipartUploadResult
+)
+
+func NewAwsChunkedReader(reader *bufio.Reader) *awsChunkedReader {
+	return &awsChunkedReader{reader: reader}
+}
@@ -0,0 +1,254 @@
+package dropbox
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileServer serves a Dropbox folder read only over HTTP, like
+// http.FileServer: folders are listed unless they hold an index.html. Files
+// are streamed from Dropbox, with the rev as ETag and Modified as
+// Last-Modified, and single byte ranges are passed on to Dropbox.
+type FileServer struct {
+	Api  *DropboxApi
+	Root string // Api.Root when empty
+	Dir  string
+}
+
+var fileServerListing = template.Must(template.New("listing").Parse(`<!doctype html>
+<meta name="viewport" content="width=device-width">
+<title>{{.Title}}</title>
+<pre>
+{{range .Entries}}<a href="{{.Href}}">{{.Name}}</a>
+{{end}}</pre>
+`))
+
+type fileServerEntry struct {
+	Name string
+	Href string
+}
+
+// The errors of ParseRange.
+var (
+	ErrRangeUnsatisfiable = errors.New("range not satisfiable")
+	ErrRangeIgnored       = errors.New("range ignored")
+)
+
+func (server *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	root := server.Root
+	if len(root) == 0 {
+		root = server.Api.Root
+	}
+	name := path.Clean("/" + r.URL.Path)
+
+	metadata, err := server.Api.GetFileMetadata_(root, path.Join("/", server.Dir, name), 25000, "", true, false, "")
+	if err != nil {
+		writeApiError(w, server.Api.Logger, err)
+		return
+	}
+
+	if !metadata.Is_dir {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			localRedirect(w, r, "../"+path.Base(name))
+			return
+		}
+		server.serveFile(w, r, root, &metadata.Content)
+		return
+	}
+
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		localRedirect(w, r, path.Base(name)+"/")
+		return
+	}
+	for i := range metadata.Contents {
+		content := &metadata.Contents[i]
+		if !content.Is_dir && strings.EqualFold(path.Base(content.Path), "index.html") {
+			server.serveFile(w, r, root, content)
+			return
+		}
+	}
+	server.serveListing(w, r, name, metadata.Contents)
+}
+
+// localRedirect redirects relatively, so it works behind http.StripPrefix.
+func localRedirect(w http.ResponseWriter, r *http.Request, newPath string) {
+	if q := r.URL.RawQuery; len(q) > 0 {
+		newPath += "?" + q
+	}
+	w.Header().Set("Location", newPath)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+func (server *FileServer) serveListing(w http.ResponseWriter, r *http.Request, name string, contents []Content) {
+	entries := []fileServerEntry{}
+	for _, content := range contents {
+		entryName := path.Base(content.Path)
+		if content.Is_dir {
+			entryName += "/"
+		}
+		entries = append(entries, fileServerEntry{Name: entryName, Href: (&url.URL{Path: entryName}).String()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return
+	}
+	fileServerListing.Execute(w, struct {
+		Title   string
+		Entries []fileServerEntry
+	}{name, entries})
+}
+
+func (server *FileServer) serveFile(w http.ResponseWriter, r *http.Request, root string, content *Content) {
+	header := w.Header()
+	etag := `"` + content.Rev + `"`
+	header.Set("ETag", etag)
+	header.Set("Accept-Ranges", "bytes")
+
+	modified, timeErr := parseDropboxTime(content.Modified)
+	if timeErr == nil {
+		header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := content.Mime_type
+	if len(contentType) == 0 {
+		contentType = mime.TypeByExtension(path.Ext(content.Path))
+	}
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	size := int64(content.Bytes)
+	offset, length, status := int64(0), size, http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); len(rangeHeader) > 0 && ifRange(r, etag, header.Get("Last-Modified")) {
+		start, n, err := ParseRange(rangeHeader, size)
+		switch err {
+		case nil:
+			offset, length, status = start, n, http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, size))
+		case ErrRangeUnsatisfiable:
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	if r.Method == "HEAD" {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		return
+	}
+
+	var reader *FileReader
+	var err *ApiError
+	if status == http.StatusPartialContent {
+		reader, err = server.Api.GetFileRange_(root, content.Path, content.Rev, offset, length)
+	} else {
+		reader, err = server.Api.GetFileReader_(root, content.Path, content.Rev)
+	}
+	if err != nil {
+		header.Del("Content-Range")
+		writeApiError(w, server.Api.Logger, err)
+		return
+	}
+	defer reader.Close()
+
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	io.Copy(w, reader)
+}
+
+// notModified checks If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, as RFC 7232 says.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); len(match) > 0 {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+// ifRange tells whether the Range header applies, If-Range holds an ETag or
+// a date.
+func ifRange(r *http.Request, etag, lastModified string) bool {
+	condition := r.Header.Get("If-Range")
+	return len(condition) == 0 || condition == etag || (len(lastModified) > 0 && condition == lastModified)
+}
+
+// ParseRange parses a Range header holding a single byte range. Headers with
+// several ranges or malformed are ignored with ErrRangeIgnored, and the whole
+// file is sent. It returns the start and the length of the range.
+func ParseRange(header string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, ErrRangeIgnored
+	}
+	spec := strings.TrimSpace(header[len("bytes="):])
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, ErrRangeIgnored
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	if len(first) == 0 {
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, ErrRangeIgnored
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, ErrRangeUnsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, ErrRangeIgnored
+	}
+	if start >= size {
+		return 0, 0, ErrRangeUnsatisfiable
+	}
+	end := size - 1
+	if len(last) > 0 {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, ErrRangeIgnored
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, nil
+}
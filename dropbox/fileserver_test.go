@@ -0,0 +1,141 @@
+package dropbox_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header        string
+		start, length int64
+		err           error
+	}{
+		{"bytes=0-4", 0, 5, nil},
+		{"bytes=3-", 3, 7, nil},
+		{"bytes=-4", 6, 4, nil},
+		{"bytes=5-100", 5, 5, nil},
+		{"bytes=10-", 0, 0, dropbox.ErrRangeUnsatisfiable},
+		{"bytes=0-1,3-4", 0, 0, dropbox.ErrRangeIgnored},
+		{"items=0-1", 0, 0, dropbox.ErrRangeIgnored},
+		{"bytes=4-2", 0, 0, dropbox.ErrRangeIgnored},
+	}
+
+	for _, c := range cases {
+		start, length, err := dropbox.ParseRange(c.header, 10)
+		if start != c.start || length != c.length || err != c.err {
+			t.Errorf("ParseRange(%q) = %d, %d, %v, want %d, %d, %v", c.header, start, length, err, c.start, c.length, c.err)
+		}
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	tree := newTree(t, map[string]string{"site/index.html": "<p>home</p>", "docs/b.txt": "b"})
+	rev, _ := tree.WriteFile("/a.txt", []byte("0123456789"))
+	server := httptest.NewServer(&dropbox.FileServer{Api: tree.Api()})
+	defer server.Close()
+
+	get := func(url string, header map[string]string) (*http.Response, string) {
+		return webdavRequest(t, "GET", server.URL+url, "", header)
+	}
+
+	resp, body := get("/a.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != "0123456789" || resp.Header.Get("ETag") != `"`+rev+`"` {
+		t.Errorf("GET returned %d %q etag %s", resp.StatusCode, body, resp.Header.Get("ETag"))
+	}
+	lastModified := resp.Header.Get("Last-Modified")
+
+	resp, body = get("/a.txt", map[string]string{"Range": "bytes=2-5"})
+	if resp.StatusCode != http.StatusPartialContent || body != "2345" || resp.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Errorf("range GET returned %d %q %s", resp.StatusCode, body, resp.Header.Get("Content-Range"))
+	}
+	if resp, _ = get("/a.txt", map[string]string{"Range": "bytes=20-"}); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range returned %d", resp.StatusCode)
+	}
+
+	if resp, _ = get("/a.txt", map[string]string{"If-None-Match": `"0", "` + rev + `"`}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("matching If-None-Match returned %d", resp.StatusCode)
+	}
+	if resp, _ = get("/a.txt", map[string]string{"If-None-Match": `"2"`}); resp.StatusCode != http.StatusOK {
+		t.Errorf("other If-None-Match returned %d", resp.StatusCode)
+	}
+	if resp, _ = get("/a.txt", map[string]string{"If-Modified-Since": lastModified}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-Modified-Since returned %d", resp.StatusCode)
+	}
+
+	resp, body = get("/", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `<a href="a.txt">a.txt</a>`) ||
+		!strings.Contains(body, `<a href="docs/">docs/</a>`) {
+		t.Errorf("listing returned %d:\n%s", resp.StatusCode, body)
+	}
+
+	if resp, body = get("/site/", nil); body != "<p>home</p>" {
+		t.Errorf("folder with an index returned %d %q", resp.StatusCode, body)
+	}
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(server.URL + "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "docs/" {
+		t.Errorf("folder without slash returned %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	if resp, _ = get("/missing.txt", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file returned %d", resp.StatusCode)
+	}
+}
+
+func TestFileServerHidesAuthErrors(t *testing.T) {
+	tree := newTree(t, map[string]string{"a.txt": "a"})
+	api := tree.Api()
+	var log bytes.Buffer
+	api.Logger = slog.New(slog.NewTextHandler(&log, nil))
+	server := httptest.NewServer(&dropbox.FileServer{Api: api})
+	defer server.Close()
+
+	if err := api.RevokeToken(); err != nil {
+		t.Fatal(err)
+	}
+	log.Reset()
+	resp, body := webdavRequest(t, "GET", server.URL+"/a.txt", "", nil)
+	if resp.StatusCode != http.StatusBadGateway || strings.Contains(body, "token") {
+		t.Errorf("GET with a revoked token returned %d %q", resp.StatusCode, body)
+	}
+	if !strings.Contains(log.String(), "dropbox refused the token") || !strings.Contains(log.String(), "status=401") {
+		t.Errorf("the refused token was not logged:\n%s", log.String())
+	}
+}
+
+func TestFileServerConflict(t *testing.T) {
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": "A file is in the way of /a.txt"}`))
+	}))
+	defer forbidden.Close()
+
+	var log bytes.Buffer
+	api := &dropbox.DropboxApi{Signer: &dropbox.OAuth2{AccessToken: "tok"}, Root: "dropbox",
+		ApiHost: forbidden.URL, ContentHost: forbidden.URL, Logger: slog.New(slog.NewTextHandler(&log, nil))}
+	server := httptest.NewServer(&dropbox.FileServer{Api: api})
+	defer server.Close()
+
+	// a 403 of Dropbox is about the path, not the token
+	resp, body := webdavRequest(t, "GET", server.URL+"/a.txt/b.txt", "", nil)
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(body, "in the way") {
+		t.Errorf("GET of a forbidden path returned %d %q", resp.StatusCode, body)
+	}
+	if strings.Contains(log.String(), "dropbox refused the token") {
+		t.Errorf("a 403 was logged as a refused token:\n%s", log.String())
+	}
+}
@@ -0,0 +1,236 @@
+package dropbox
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DropboxFS is a read only fs.FS over a Dropbox folder. Files are streamed
+// when first read, Stat and ReadDir only fetch metadata.
+type DropboxFS struct {
+	api  *DropboxApi
+	root string
+	dir  string
+}
+
+var (
+	_ fs.StatFS     = (*DropboxFS)(nil)
+	_ fs.ReadDirFS  = (*DropboxFS)(nil)
+	_ fs.ReadFileFS = (*DropboxFS)(nil)
+)
+
+// NewFS returns the file system rooted at the folder dir, root is api.Root
+// when empty.
+func NewFS(api *DropboxApi, root, dir string) *DropboxFS {
+	if len(root) == 0 {
+		root = api.Root
+	}
+	return &DropboxFS{api: api, root: root, dir: cleanRemotePath(dir)}
+}
+
+// remote returns the Dropbox path of the fs.FS name.
+func (fsys *DropboxFS) remote(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join("/", fsys.dir, name), nil
+}
+
+func (fsys *DropboxFS) metadata(op, name string, list bool) (*PathMetadata, error) {
+	remote, err := fsys.remote(op, name)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, apierr := fsys.api.GetFileMetadata_(fsys.root, remote, 25000, "", list, false, "")
+	if apierr != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: apierr}
+	}
+	return metadata, nil
+}
+
+func (fsys *DropboxFS) Open(name string) (fs.File, error) {
+	metadata, err := fsys.metadata("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &dropboxFile{fsys: fsys, name: name, metadata: metadata}, nil
+}
+
+func (fsys *DropboxFS) Stat(name string) (fs.FileInfo, error) {
+	metadata, err := fsys.metadata("stat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &contentInfo{name: path.Base(name), content: &metadata.Content}, nil
+}
+
+func (fsys *DropboxFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	metadata, err := fsys.metadata("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !metadata.Is_dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return dirEntries(metadata.Contents), nil
+}
+
+func (fsys *DropboxFS) ReadFile(name string) ([]byte, error) {
+	remote, err := fsys.remote("read", name)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, apierr := fsys.api.GetFileReader_(fsys.root, remote, "")
+	if apierr != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: apierr}
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+// Sub returns the file system rooted at the folder dir.
+func (fsys *DropboxFS) Sub(dir string) (fs.FS, error) {
+	remote, err := fsys.remote("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return &DropboxFS{api: fsys.api, root: fsys.root, dir: remote}, nil
+}
+
+func dirEntries(contents []Content) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(contents))
+	for i := range contents {
+		content := &contents[i]
+		entries = append(entries, &contentInfo{name: path.Base(content.Path), content: content})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// dropboxFile is an opened file or folder. A file is downloaded from its
+// revision at open time, so a file replaced meanwhile reads consistently.
+type dropboxFile struct {
+	fsys     *DropboxFS
+	name     string
+	metadata *PathMetadata
+	reader   *FileReader
+	entries  []fs.DirEntry
+	listed   bool
+	closed   bool
+}
+
+func (file *dropboxFile) Stat() (fs.FileInfo, error) {
+	return &contentInfo{name: path.Base(file.name), content: &file.metadata.Content}, nil
+}
+
+func (file *dropboxFile) Read(p []byte) (int, error) {
+	if file.closed {
+		return 0, &fs.PathError{Op: "read", Path: file.name, Err: fs.ErrClosed}
+	}
+	if file.metadata.Is_dir {
+		return 0, &fs.PathError{Op: "read", Path: file.name, Err: fs.ErrInvalid}
+	}
+
+	if file.reader == nil {
+		remote, _ := file.fsys.remote("read", file.name)
+		reader, err := file.fsys.api.GetFileReader_(file.fsys.root, remote, file.metadata.Rev)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: file.name, Err: err}
+		}
+		file.reader = reader
+	}
+	return file.reader.Read(p)
+}
+
+func (file *dropboxFile) Close() error {
+	if file.closed {
+		return &fs.PathError{Op: "close", Path: file.name, Err: fs.ErrClosed}
+	}
+	file.closed = true
+	if file.reader != nil {
+		return file.reader.Close()
+	}
+	return nil
+}
+
+// ReadDir lists the folder as fs.ReadDirFile does, from the metadata fetched
+// at open time.
+func (file *dropboxFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !file.metadata.Is_dir {
+		return nil, &fs.PathError{Op: "readdir", Path: file.name, Err: fs.ErrInvalid}
+	}
+	if !file.listed {
+		file.entries, file.listed = dirEntries(file.metadata.Contents), true
+	}
+
+	count := len(file.entries)
+	if n > 0 && n < count {
+		count = n
+	}
+	if n > 0 && count == 0 {
+		return nil, io.EOF
+	}
+
+	entries := file.entries[:count]
+	file.entries = file.entries[count:]
+	return entries, nil
+}
+
+// contentInfo is both the fs.FileInfo and the fs.DirEntry of a Content.
+type contentInfo struct {
+	name    string
+	content *Content
+}
+
+func (info *contentInfo) Name() string {
+	if info.name == "/" {
+		return "."
+	}
+	return info.name
+}
+
+func (info *contentInfo) Size() int64 {
+	return int64(info.content.Bytes)
+}
+
+func (info *contentInfo) Mode() fs.FileMode {
+	if info.content.Is_dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (info *contentInfo) ModTime() time.Time {
+	mtime, _ := info.content.mtime()
+	return mtime
+}
+
+func (info *contentInfo) IsDir() bool {
+	return info.content.Is_dir
+}
+
+// Sys returns the *Content.
+func (info *contentInfo) Sys() interface{} {
+	return info.content
+}
+
+func (info *contentInfo) Type() fs.FileMode {
+	return info.Mode().Type()
+}
+
+func (info *contentInfo) Info() (fs.FileInfo, error) {
+	return info, nil
+}
+
+// FileInfo returns content as an fs.FileInfo.
+func (content *Content) FileInfo() fs.FileInfo {
+	return &contentInfo{name: path.Base(strings.TrimSuffix(content.Path, "/")), content: content}
+}
@@ -0,0 +1,67 @@
+package dropbox_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+// newTree starts a server holding files, keyed by path without the leading
+// slash. A key ending with a slash is an empty folder.
+func newTree(t *testing.T, files map[string]string) *dropboxtest.Server {
+	server := dropboxtest.NewServer()
+	t.Cleanup(server.Close)
+	for name, data := range files {
+		if name[len(name)-1] == '/' {
+			server.Mkdir("/" + name)
+		} else {
+			server.WriteFile("/"+name, []byte(data))
+		}
+	}
+	return server
+}
+
+func TestDropboxFS(t *testing.T) {
+	server := newTree(t, map[string]string{
+		"a.txt":           "hello",
+		"docs/b.txt":      "world",
+		"docs/deep/c.txt": "!",
+	})
+
+	fsys := dropbox.NewFS(server.Api(), "", "")
+	if err := fstest.TestFS(fsys, "a.txt", "docs/b.txt", "docs/deep/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "docs/b.txt")
+	if err != nil || string(data) != "world" {
+		t.Errorf("ReadFile returned %q, %v", data, err)
+	}
+
+	sub, err := fs.Sub(fsys, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "b.txt", "deep/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDropboxFSNotExist(t *testing.T) {
+	server := newTree(t, map[string]string{"a.txt": "hello"})
+	fsys := dropbox.NewFS(server.Api(), "", "")
+
+	if _, err := fsys.Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of a missing file returned %v", err)
+	}
+	if _, err := fs.ReadFile(fsys, "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile of a missing file returned %v", err)
+	}
+	if _, err := fsys.Open("../a.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open of an invalid name returned %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package dropbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const IgnoreFileName = ".dropboxignore"
+
+// IgnoreRules decides which paths bulk operations leave alone. Patterns use
+// the .gitignore syntax and match case-insensitively, like Dropbox paths.
+type IgnoreRules struct {
+	MaxSize int64 // files bigger than this are ignored, no limit when 0
+
+	global []*ignorePattern
+	dirs   map[string][]*ignorePattern // from ignore files, keyed by lower cased folder
+}
+
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// NewIgnoreRules returns rules holding the global patterns, which are
+// relative to the root of the operation.
+func NewIgnoreRules(patterns ...string) *IgnoreRules {
+	rules := &IgnoreRules{dirs: make(map[string][]*ignorePattern)}
+	for _, line := range patterns {
+		if pattern, ok := parseIgnorePattern(line); ok {
+			rules.global = append(rules.global, pattern)
+		}
+	}
+	return rules
+}
+
+// Add reads an ignore file found in folder dir, replacing the rules
+// previously added for that folder.
+func (rules *IgnoreRules) Add(dir string, r io.Reader) error {
+	patterns := []*ignorePattern{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if pattern, ok := parseIgnorePattern(scanner.Text()); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if rules.dirs == nil {
+		rules.dirs = make(map[string][]*ignorePattern)
+	}
+	rules.dirs[strings.ToLower(strings.Trim(dir, "/"))] = patterns
+	return nil
+}
+
+func (rules *IgnoreRules) remove(dir string) {
+	delete(rules.dirs, strings.ToLower(strings.Trim(dir, "/")))
+}
+
+// LoadLocal adds every ignore file found below localDir.
+func (rules *IgnoreRules) LoadLocal(localDir string) error {
+	return filepath.Walk(localDir, func(local string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != IgnoreFileName {
+			return nil
+		}
+
+		file, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		dir, _ := filepath.Rel(localDir, filepath.Dir(local))
+		if dir == "." {
+			dir = ""
+		}
+		return rules.Add(filepath.ToSlash(dir), file)
+	})
+}
+
+// LoadIgnoreRules adds every ignore file found below remotePath on Dropbox.
+// The folders are listed rather than searched, a search returns a capped
+// number of files. Ignored folders are not entered.
+func (api *DropboxApi) LoadIgnoreRules(rules *IgnoreRules, root, remotePath string) *ApiError {
+	return api.Walk(root, remotePath, rules, func(p string, metadata *PathMetadata, err *ApiError) *ApiError {
+		// a folder not created yet or removed meanwhile has no rules.
+		if err != nil && err.Code == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func (api *DropboxApi) addRemoteIgnoreFile(rules *IgnoreRules, root, prefix, remote string) *ApiError {
+	dir, ok := relRemotePath(prefix, path.Dir(remote))
+	if !ok {
+		return nil
+	}
+
+	reader, err := api.GetFileReader_(root, remote, "")
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if ioerr := rules.Add(dir, reader); ioerr != nil {
+		return api.toApiError(ioerr)
+	}
+	return nil
+}
+
+// syncIgnore keeps the ignore files of a synced folder in step with its
+// delta, so that a sync run reads only those the delta reports as changed
+// instead of listing the whole folder.
+type syncIgnore struct {
+	api        *DropboxApi
+	rules      *IgnoreRules
+	root       string
+	remotePath string
+	prefix     string
+	files      map[string]*SyncIgnoreFile // by lower cased folder, relative to prefix
+}
+
+// newSyncIgnore adds to rules the ignore files cached for cursor, or those
+// found by listing remotePath when there is no cursor or no cache.
+func (api *DropboxApi) newSyncIgnore(rules *IgnoreRules, root, remotePath, cursor string, cached map[string]*SyncIgnoreFile) (*syncIgnore, *ApiError) {
+	s := &syncIgnore{api: api, rules: rules, root: root, remotePath: remotePath, prefix: cleanRemotePath(remotePath)}
+	if rules == nil {
+		return s, nil
+	}
+
+	if len(cursor) == 0 || cached == nil {
+		return s, s.reload()
+	}
+	s.files = cached
+	for dir, file := range cached {
+		if ioerr := rules.Add(dir, strings.NewReader(file.Text)); ioerr != nil {
+			return nil, api.toApiError(ioerr)
+		}
+	}
+	return s, nil
+}
+
+// reload lists remotePath again for its ignore files, ignored folders are
+// not entered.
+func (s *syncIgnore) reload() *ApiError {
+	for dir := range s.files {
+		s.rules.remove(dir)
+	}
+	s.files = make(map[string]*SyncIgnoreFile)
+
+	return s.api.Walk(s.root, s.remotePath, nil, func(p string, metadata *PathMetadata, err *ApiError) *ApiError {
+		// a folder not created yet or removed meanwhile has no rules.
+		if err != nil && err.Code == http.StatusNotFound {
+			return nil
+		}
+		if err != nil || !metadata.Is_dir {
+			return err
+		}
+
+		if rel, _ := relRemotePath(s.prefix, p); len(rel) > 0 && s.rules.Ignored(rel, true, 0) {
+			return SkipDir
+		}
+		for i := range metadata.Contents {
+			if content := &metadata.Contents[i]; !content.Is_dir && strings.EqualFold(path.Base(content.Path), IgnoreFileName) {
+				if err = s.read(content); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// read adds the ignore file content, unless its revision is known already.
+func (s *syncIgnore) read(content *Content) *ApiError {
+	dir, ok := relRemotePath(s.prefix, path.Dir(content.Path))
+	if !ok {
+		return nil
+	}
+	key := strings.ToLower(dir)
+	if file := s.files[key]; file != nil && file.Rev == content.Rev {
+		return nil
+	}
+
+	reader, err := s.api.GetFileReader_(s.root, content.Path, content.Rev)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	text, ioerr := ioutil.ReadAll(reader)
+	if ioerr == nil {
+		ioerr = s.rules.Add(dir, bytes.NewReader(text))
+	}
+	if ioerr != nil {
+		return s.api.toApiError(ioerr)
+	}
+	s.files[key] = &SyncIgnoreFile{Rev: content.Rev, Text: string(text)}
+	return nil
+}
+
+// update applies the ignore files changed in a page of the delta, before
+// the page itself is, so that its other entries see the new rules. A reset
+// of a cursor lists the folder again.
+func (s *syncIgnore) update(cursor string, delta *DeltaResult) *ApiError {
+	if s.rules == nil {
+		return nil
+	}
+	if delta.Reset && len(cursor) > 0 {
+		return s.reload()
+	}
+
+	for _, entry := range delta.Entries {
+		rel, ok := relRemotePath(s.prefix, entry.Path)
+		if !ok {
+			continue
+		}
+		isIgnoreFile := strings.EqualFold(path.Base(entry.Path), IgnoreFileName)
+
+		switch {
+		case entry.Metadata == nil && isIgnoreFile:
+			s.forget(path.Dir("/"+rel), false)
+		case entry.Metadata == nil:
+			// a deleted folder takes its ignore files with it.
+			s.forget("/"+rel, true)
+		case isIgnoreFile && !entry.Metadata.Is_dir:
+			if err := s.read(&entry.Metadata.Content); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// forget drops the ignore file of the slash rooted dir and, when below is
+// set, those of the folders inside it.
+func (s *syncIgnore) forget(dir string, below bool) {
+	dir = strings.ToLower(strings.TrimPrefix(dir, "/"))
+	for key := range s.files {
+		if key == dir || (below && (len(dir) == 0 || strings.HasPrefix(key, dir+"/"))) {
+			delete(s.files, key)
+			s.rules.remove(key)
+		}
+	}
+}
+
+// Ignored tells whether rel, a slash separated path relative to the root of
+// the operation, is left out. Everything inside an ignored folder is too.
+func (rules *IgnoreRules) Ignored(rel string, isDir bool, size int64) bool {
+	if rules == nil {
+		return false
+	}
+
+	rel = strings.ToLower(strings.Trim(rel, "/"))
+	if len(rel) == 0 {
+		return false
+	}
+
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if rules.match(parts[:i], true) {
+			return true
+		}
+	}
+	if rules.match(parts, isDir) {
+		return true
+	}
+	return !isDir && rules.MaxSize > 0 && size > rules.MaxSize
+}
+
+// match applies the global patterns and then the ignore files from the
+// outermost folder in, the last matching pattern wins.
+func (rules *IgnoreRules) match(parts []string, isDir bool) bool {
+	ignored := false
+	apply := func(sub []string, patterns []*ignorePattern) {
+		for _, pattern := range patterns {
+			if pattern.dirOnly && !isDir {
+				continue
+			}
+			if matchSegments(pattern.segments, sub) {
+				ignored = !pattern.negate
+			}
+		}
+	}
+
+	apply(parts, rules.global)
+	for i := 0; i < len(parts); i++ {
+		if patterns, ok := rules.dirs[strings.Join(parts[:i], "/")]; ok {
+			apply(parts[i:], patterns)
+		}
+	}
+	return ignored
+}
+
+func parseIgnorePattern(line string) (*ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if len(line) == 0 || line[0] == '#' {
+		return nil, false
+	}
+
+	pattern := &ignorePattern{}
+	if line[0] == '!' {
+		pattern.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	// a pattern without a slash matches at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimLeft(line, "/")
+	if len(line) == 0 {
+		return nil, false
+	}
+
+	pattern.segments = strings.Split(strings.ToLower(line), "/")
+	if !anchored {
+		pattern.segments = append([]string{"**"}, pattern.segments...)
+	}
+	return pattern, true
+}
+
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchSegments(pattern[1:], parts[1:])
+}
@@ -0,0 +1,84 @@
+package dropbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := NewIgnoreRules("*.tmp", "/build/", "cache/", "docs/**/draft*", "# comment", "")
+	rules.MaxSize = 100
+	rules.Add("src", strings.NewReader("*.o\n!keep.o\n/local.cfg\n"))
+
+	cases := []struct {
+		rel   string
+		isDir bool
+		size  int64
+		want  bool
+	}{
+		{"a.txt", false, 10, false},
+		{"a.TMP", false, 10, true},
+		{"sub/b.tmp", false, 10, true},
+		{"build", true, 0, true},
+		{"build/out.bin", false, 10, true},
+		{"sub/build", true, 0, false},
+		{"build", false, 10, false},
+		{"x/cache", true, 0, true},
+		{"x/cache/y", false, 10, true},
+		{"docs/draft1.md", false, 10, true},
+		{"docs/a/b/draft2.md", false, 10, true},
+		{"docs/final.md", false, 10, false},
+		{"src/main.o", false, 10, true},
+		{"src/keep.o", false, 10, false},
+		{"main.o", false, 10, false},
+		{"src/local.cfg", false, 10, true},
+		{"src/sub/local.cfg", false, 10, false},
+		{"big.bin", false, 101, true},
+		{"bigdir", true, 101, false},
+	}
+
+	for _, c := range cases {
+		if got := rules.Ignored(c.rel, c.isDir, c.size); got != c.want {
+			t.Errorf("Ignored(%q, %v, %d) = %v, want %v", c.rel, c.isDir, c.size, got, c.want)
+		}
+	}
+
+	var none *IgnoreRules
+	if none.Ignored("a.tmp", false, 0) {
+		t.Errorf("nil rules ignore paths")
+	}
+}
+
+func TestIgnoreRulesLoadLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godropbox-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, IgnoreFileName), []byte("*.log\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "a", "b", IgnoreFileName), []byte("!important.log\n*.bak\n"), 0644)
+
+	rules := NewIgnoreRules()
+	if err = rules.LoadLocal(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"x.log":               true,
+		"a/b/x.log":           true,
+		"a/b/important.log":   false,
+		"a/b/c/important.log": false,
+		"a/x.bak":             false,
+		"a/b/x.bak":           true,
+	}
+	for rel, want := range cases {
+		if got := rules.Ignored(rel, false, 0); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package dropbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger logs the requests of a DropboxApi, a *slog.Logger is one. Every
+// request is logged once its response body is closed, at Info, or at Warn
+// when it failed, with the attributes endpoint, method, url, status,
+// latency (until the response headers), bytes_sent, bytes_received and
+// attempt.
+//
+// The URL is logged without credentials or secret parameters, and no
+// header is logged, so the token a Signer adds never is.
+type Logger interface {
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+type attemptKey struct{}
+
+// WithAttempt marks the requests made with ctx as the attempt-th try of a
+// call, for the log. Middlewares retrying requests can use it.
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+func attemptOf(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// secretParams never reach the log, the v1 API takes none of them in URLs
+// but a proxy or a middleware could add them.
+var secretParams = []string{"access_token", "refresh_token", "code", "client_secret",
+	"oauth_token", "oauth_token_secret", "oauth_signature"}
+
+func redactedUrl(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	for _, name := range secretParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, "REDACTED")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// endpointName returns the name in endpoints of the call requesting u, the
+// path when it is none.
+func endpointName(u *url.URL) string {
+	for name, ep := range endpoints {
+		prefix := ep.path
+		if i := strings.Index(prefix, "<"); i >= 0 {
+			prefix = prefix[:i]
+			if strings.HasPrefix(u.Path, prefix) {
+				return name
+			}
+		} else if u.Path == prefix {
+			return name
+		}
+	}
+	return u.Path
+}
+
+// logged logs what next sends to api.Logger.
+func (api *DropboxApi) logged(next Sender) Sender {
+	return func(req *http.Request) (*http.Response, *ApiError) {
+		start := time.Now()
+		resp, err := next(req)
+
+		args := []interface{}{
+			"endpoint", endpointName(req.URL),
+			"method", req.Method,
+			"url", redactedUrl(req.URL),
+		}
+		sent := req.ContentLength
+		if sent < 0 {
+			sent = 0
+		}
+		if err != nil || resp == nil {
+			args = append(args, "latency", time.Since(start), "bytes_sent", sent, "attempt", attemptOf(req.Context()))
+			if err != nil {
+				args = append(args, "error", err.ErrorMsg)
+			}
+			api.Logger.Warn("dropbox request", args...)
+			return resp, err
+		}
+
+		args = append(args, "status", resp.StatusCode, "latency", time.Since(start), "bytes_sent", sent)
+		resp.Body = &loggedBody{ReadCloser: resp.Body, done: func(received int64) {
+			args = append(args, "bytes_received", received, "attempt", attemptOf(req.Context()))
+			if resp.StatusCode >= http.StatusBadRequest {
+				api.Logger.Warn("dropbox request", args...)
+			} else {
+				api.Logger.Info("dropbox request", args...)
+			}
+		}}
+		return resp, nil
+	}
+}
+
+// loggedBody counts the bytes read from a response body and calls done
+// with them when it is closed.
+type loggedBody struct {
+	io.ReadCloser
+	received int64
+	once     sync.Once
+	done     func(received int64)
+}
+
+func (body *loggedBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.received += int64(n)
+	return n, err
+}
+
+func (body *loggedBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(func() { body.done(body.received) })
+	return err
+}
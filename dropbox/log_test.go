@@ -0,0 +1,92 @@
+package dropbox_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+func TestLogger(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.AccessToken = "secret-token"
+	server.WriteFile("/a.txt", []byte("hello"))
+
+	var out bytes.Buffer
+	api := server.Api()
+	api.Logger = slog.New(slog.NewJSONHandler(&out, nil))
+	api.Client = &http.Client{Transport: &dropboxtest.FaultTransport{Base: server.Client().Transport,
+		Rules: []dropboxtest.FaultRule{{Fault: dropboxtest.FaultUnavailable, Path: "/chunked_upload", Calls: []int{1}}}}}
+
+	api.GetFile("/a.txt")
+	api.GetFileMetadata("/missing.txt")
+	api.UploadReaderByChunked(strings.NewReader("abcdef"), "/up.txt", 10, 2)
+
+	if strings.Contains(out.String(), "secret-token") {
+		t.Fatalf("the token was logged:\n%s", out.String())
+	}
+	lines := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, record)
+	}
+	if len(lines) != 5 {
+		t.Fatalf("logged %d requests:\n%s", len(lines), out.String())
+	}
+
+	want := []struct {
+		level, endpoint, method   string
+		status, received, attempt float64
+	}{
+		{"INFO", "gets", "GET", 200, 5, 1},
+		{"WARN", "metadata", "GET", 404, -1, 1},
+		{"WARN", "chunked_upload", "PUT", 503, -1, 1},
+		{"INFO", "chunked_upload", "PUT", 200, -1, 2},
+		{"INFO", "commit_chunked_upload", "POST", 200, -1, 1},
+	}
+	for i, w := range want {
+		line := lines[i]
+		if line["level"] != w.level || line["endpoint"] != w.endpoint || line["method"] != w.method ||
+			line["status"] != w.status || line["attempt"] != w.attempt || line["msg"] != "dropbox request" {
+			t.Errorf("logged %v, want %+v", line, w)
+		}
+		if w.received >= 0 && line["bytes_received"] != w.received {
+			t.Errorf("logged %v bytes received, want %v", line["bytes_received"], w.received)
+		}
+		if _, ok := line["latency"]; !ok {
+			t.Errorf("logged no latency in %v", line)
+		}
+	}
+	if lines[2]["bytes_sent"] != float64(6) || !strings.Contains(lines[1]["url"].(string), "/1/metadata/") {
+		t.Errorf("logged %v and %v", lines[2], lines[1])
+	}
+}
+
+func TestLoggerRedactsUrl(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+
+	var out bytes.Buffer
+	api := server.Api()
+	api.Logger = slog.New(slog.NewTextHandler(&out, nil))
+	api.Middlewares = []dropbox.Middleware{dropbox.BeforeSend(func(req *http.Request) *dropbox.ApiError {
+		req.URL.RawQuery += "&access_token=query-token"
+		return nil
+	})}
+
+	if _, err := api.GetAccountInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "query-token") || !strings.Contains(out.String(), "access_token=REDACTED") {
+		t.Errorf("logged %s", out.String())
+	}
+}
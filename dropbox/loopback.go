@@ -0,0 +1,156 @@
+package dropbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// the token flow returns the token in the url fragment, which only the
+// browser sees, so this page sends it back as query parameters.
+var loopbackFragmentPage = template.Must(template.New("fragment").Parse(`<!DOCTYPE html>
+<html><head><title>Dropbox authorization</title></head>
+<body>
+<p id="msg">Completing authorization ...</p>
+<script>
+if (window.location.hash.length > 1) {
+	window.location.replace(window.location.pathname + "?" + window.location.hash.substring(1));
+} else {
+	document.getElementById("msg").textContent = "No authorization found in the address.";
+}
+</script>
+</body></html>
+`))
+
+var loopbackDonePage = template.Must(template.New("done").Parse(`<!DOCTYPE html>
+<html><head><title>Dropbox authorization</title></head>
+<body><p>{{.}}</p></body></html>
+`))
+
+type loopbackResult struct {
+	oauth2 *OAuth2
+	err    *ApiError
+}
+
+// AuthorizeLoopback runs the whole authorization for command line tools. It
+// listens on addr ("127.0.0.1:0" when empty), calls open with the authorize
+// url using that listener as redirect, and waits for the browser to come back.
+// Without an AppSecret the token flow is used, otherwise the code flow.
+// A nil open prints the url, a zero timeout waits forever.
+func (config *OAuth2Config) AuthorizeLoopback(addr string, open func(authUrl string) error, timeout time.Duration) (*OAuth2, *ApiError) {
+	if len(addr) == 0 {
+		addr = "127.0.0.1:0"
+	}
+	if open == nil {
+		open = func(authUrl string) error {
+			fmt.Printf("%s\n", authUrl)
+			return nil
+		}
+	}
+
+	listener, neterr := net.Listen("tcp", addr)
+	if neterr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: neterr.Error()}
+	}
+	defer listener.Close()
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+
+	loopback := *config
+	loopback.RedirectUri = fmt.Sprintf("http://%s/authorized", listener.Addr())
+
+	results := make(chan *loopbackResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorized", func(w http.ResponseWriter, r *http.Request) {
+		loopback.serveRedirect(w, r, state, results)
+	})
+	go http.Serve(listener, mux)
+
+	if openerr := open(loopback.authorizeUrl(state)); openerr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: openerr.Error()}
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	select {
+	case result := <-results:
+		return result.oauth2, result.err
+	case <-expired:
+		return nil, &ApiError{Code: -1, ErrorMsg: "authorization timed out ."}
+	}
+}
+
+func (config *OAuth2Config) authorizeUrl(state string) string {
+	if len(config.AppSecret) > 0 {
+		return config.AuthCodeURL(state)
+	}
+
+	values := url.Values{}
+	values.Add("response_type", "token")
+	values.Add("client_id", config.AppKey)
+	values.Add("redirect_uri", config.RedirectUri)
+	values.Add("state", state)
+	return fmt.Sprintf("%s?%s", apiUrls["authorize-url"], values.Encode())
+}
+
+func (config *OAuth2Config) serveRedirect(w http.ResponseWriter, r *http.Request, state string, results chan *loopbackResult) {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loopbackFragmentPage.Execute(w, nil)
+		return
+	}
+
+	if query.Get("state") != state {
+		w.WriteHeader(http.StatusBadRequest)
+		loopbackDonePage.Execute(w, "Authorization failed: state does not match.")
+		return
+	}
+
+	result := &loopbackResult{}
+	switch {
+	case len(query.Get("error")) > 0:
+		msg := query.Get("error")
+		if desc := query.Get("error_description"); len(desc) > 0 {
+			msg = fmt.Sprintf("%s: %s", msg, desc)
+		}
+		result.err = &ApiError{Code: -1, ErrorMsg: msg}
+	case len(query.Get("code")) > 0:
+		result.oauth2, result.err = config.Exchange(query.Get("code"))
+	case len(query.Get("access_token")) > 0:
+		result.oauth2 = &OAuth2{AccessToken: query.Get("access_token"), TokenType: query.Get("token_type"), Uid: query.Get("uid")}
+	default:
+		result.err = &ApiError{Code: -1, ErrorMsg: "no code or access_token in the redirect ."}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if result.err != nil {
+		loopbackDonePage.Execute(w, "Authorization failed: "+result.err.Error())
+	} else {
+		loopbackDonePage.Execute(w, "Authorization done, you can close this window.")
+	}
+
+	select {
+	case results <- result:
+	default:
+	}
+}
+
+func randomState() (string, *ApiError) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", &ApiError{Code: -1, ErrorMsg: err.Error()}
+	}
+	return hex.EncodeToString(buf), nil
+}
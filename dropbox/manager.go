@@ -0,0 +1,288 @@
+package dropbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AccountManager holds a DropboxApi per Dropbox account, keyed by Uid. All
+// accounts share one connection pool and one request rate budget, each
+// account has its own concurrency limit, and accounts unused for
+// IdleTimeout are evicted.
+type AccountManager struct {
+	Root   string
+	Locale string
+
+	// ApiHost, ContentHost and Logger are given to every DropboxApi.
+	ApiHost     string
+	ContentHost string
+	Logger      Logger
+
+	MaxConcurrent     int           // requests in flight per account, unlimited when 0
+	RequestsPerSecond float64       // shared by all accounts, unlimited when 0
+	IdleTimeout       time.Duration // never evicts when 0
+	Transport         http.RoundTripper
+
+	// Signer builds the signer of an account not held yet, Api fails for
+	// unknown accounts when nil.
+	Signer func(uid string) (RequestSinger, *ApiError)
+
+	mu       sync.Mutex
+	accounts map[string]*managedAccount
+	limiter  *rateLimiter
+}
+
+type managedAccount struct {
+	api      *DropboxApi
+	slots    chan struct{}
+	inFlight int
+	lastUsed time.Time
+}
+
+func (manager *AccountManager) init() {
+	if manager.accounts == nil {
+		manager.accounts = make(map[string]*managedAccount)
+	}
+	if manager.limiter == nil && manager.RequestsPerSecond > 0 {
+		manager.limiter = newRateLimiter(manager.RequestsPerSecond)
+	}
+}
+
+// Add registers the signer of an account. For an account held already only
+// the signer is replaced, the requests in flight keep counting against the
+// concurrency limit of the account.
+func (manager *AccountManager) Add(uid string, signer RequestSinger) *DropboxApi {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.init()
+	manager.evictLocked(time.Now())
+	return manager.addLocked(uid, signer).api
+}
+
+func (manager *AccountManager) addLocked(uid string, signer RequestSinger) *managedAccount {
+	account, ok := manager.accounts[uid]
+	if !ok {
+		account = &managedAccount{}
+		if manager.MaxConcurrent > 0 {
+			account.slots = make(chan struct{}, manager.MaxConcurrent)
+		}
+	}
+	account.lastUsed = time.Now()
+
+	// a new DropboxApi rather than a new Signer on the old one, which
+	// requests in flight may be reading.
+	transport := &accountTransport{manager: manager, account: account}
+	account.api = &DropboxApi{Signer: signer, Root: manager.Root, Locale: manager.Locale,
+		ApiHost: manager.ApiHost, ContentHost: manager.ContentHost, Logger: manager.Logger, Client: &http.Client{Transport: transport}}
+
+	manager.accounts[uid] = account
+	return account
+}
+
+// Api returns the DropboxApi of an account, building it with Signer when
+// the account is not held. Signer runs without the lock of the manager, it
+// may be slow or call the manager.
+//
+// A request holds a concurrency slot of its account until its response body
+// is read to the end or closed, so a FileReader must be closed.
+func (manager *AccountManager) Api(uid string) (*DropboxApi, *ApiError) {
+	if api, ok := manager.held(uid); ok {
+		return api, nil
+	}
+
+	if manager.Signer == nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: "unknown account " + uid + " ."}
+	}
+	signer, err := manager.Signer(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	// built meanwhile by another call.
+	if account, ok := manager.accounts[uid]; ok {
+		account.lastUsed = time.Now()
+		return account.api, nil
+	}
+	return manager.addLocked(uid, signer).api, nil
+}
+
+func (manager *AccountManager) held(uid string) (*DropboxApi, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.init()
+	now := time.Now()
+	manager.evictLocked(now)
+
+	account, ok := manager.accounts[uid]
+	if !ok {
+		return nil, false
+	}
+	account.lastUsed = now
+	return account.api, true
+}
+
+func (manager *AccountManager) Remove(uid string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	delete(manager.accounts, uid)
+}
+
+func (manager *AccountManager) Len() int {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return len(manager.accounts)
+}
+
+// Evict drops the accounts idle for IdleTimeout and returns their Uid. It is
+// also done by Add and Api.
+func (manager *AccountManager) Evict() []string {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.evictLocked(time.Now())
+}
+
+func (manager *AccountManager) evictLocked(now time.Time) []string {
+	evicted := []string{}
+	if manager.IdleTimeout <= 0 {
+		return evicted
+	}
+
+	for uid, account := range manager.accounts {
+		if account.inFlight == 0 && now.Sub(account.lastUsed) >= manager.IdleTimeout {
+			delete(manager.accounts, uid)
+			evicted = append(evicted, uid)
+		}
+	}
+	return evicted
+}
+
+func (manager *AccountManager) transport() http.RoundTripper {
+	if manager.Transport != nil {
+		return manager.Transport
+	}
+	return http.DefaultTransport
+}
+
+// accountTransport applies the limits of an account, a request holds its
+// slot until its body is read to the end or closed.
+type accountTransport struct {
+	manager *AccountManager
+	account *managedAccount
+}
+
+func (transport *accountTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if slots := transport.account.slots; slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	transport.touch(1)
+
+	once := &sync.Once{}
+	release := func() {
+		once.Do(func() {
+			transport.touch(-1)
+			if transport.account.slots != nil {
+				<-transport.account.slots
+			}
+		})
+	}
+
+	if limiter := transport.manager.limiter; limiter != nil {
+		if err := limiter.wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	resp, err := transport.manager.transport().RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+func (transport *accountTransport) touch(delta int) {
+	transport.manager.mu.Lock()
+	defer transport.manager.mu.Unlock()
+	transport.account.inFlight += delta
+	transport.account.lastUsed = time.Now()
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (body *releasingBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	if err == io.EOF {
+		body.release()
+	}
+	return n, err
+}
+
+func (body *releasingBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.release()
+	return err
+}
+
+// rateLimiter is a token bucket holding up to one second of requests, and
+// at least one.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (limiter *rateLimiter) wait(ctx context.Context) error {
+	for {
+		limiter.mu.Lock()
+		now := time.Now()
+		limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+		if limiter.tokens > limiter.burst {
+			limiter.tokens = limiter.burst
+		}
+		limiter.last = now
+
+		if limiter.tokens >= 1 {
+			limiter.tokens--
+			limiter.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - limiter.tokens) / limiter.rate * float64(time.Second))
+		limiter.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
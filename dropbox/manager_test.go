@@ -0,0 +1,208 @@
+package dropbox
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccountManagerConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	current, max := 0, 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		current--
+		mu.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	manager := &AccountManager{Root: "dropbox", MaxConcurrent: 2}
+	api := manager.Add("1", &OAuth2{AccessToken: "a"})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := make(map[string]interface{})
+			if err := api.jsonReponseByGet(server.URL, &res); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max > 2 {
+		t.Errorf("%d requests in flight for one account, limit is 2", max)
+	}
+}
+
+func TestAccountManagerReAdd(t *testing.T) {
+	block := make(chan struct{})
+	var mu sync.Mutex
+	tokens := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		mu.Unlock()
+		if r.URL.Path == "/slow" {
+			<-block
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	manager := &AccountManager{Root: "dropbox", MaxConcurrent: 1}
+	old := manager.Add("1", &OAuth2{AccessToken: "old"})
+	done := make(chan *ApiError)
+	go func() {
+		res := make(map[string]interface{})
+		done <- old.jsonReponseByGet(server.URL+"/slow", &res)
+	}()
+	for inFlight := 0; inFlight == 0; time.Sleep(time.Millisecond) {
+		manager.mu.Lock()
+		inFlight = manager.accounts["1"].inFlight
+		manager.mu.Unlock()
+	}
+
+	// the request in flight still holds the only slot of the account
+	api := manager.Add("1", &OAuth2{AccessToken: "new"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if resp, err := api.Client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Error("Add of a held account gave it another slot")
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]interface{})
+	if err := api.jsonReponseByGet(server.URL, &res); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last := tokens[len(tokens)-1]; last != "Bearer new" {
+		t.Errorf("the new signer was not used, the request had %q", last)
+	}
+}
+
+func TestAccountManagerEvict(t *testing.T) {
+	built := 0
+	manager := &AccountManager{Root: "dropbox", IdleTimeout: 10 * time.Millisecond,
+		Signer: func(uid string) (RequestSinger, *ApiError) {
+			built++
+			return &OAuth2{AccessToken: "token-" + uid, Uid: uid}, nil
+		}}
+
+	first, err := manager.Api("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := manager.Api("42"); again != first || built != 1 {
+		t.Errorf("Api built the account again")
+	}
+	if first.Signer.(*OAuth2).AccessToken != "token-42" || first.Root != "dropbox" {
+		t.Errorf("api = %v", first)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if evicted := manager.Evict(); len(evicted) != 1 || evicted[0] != "42" || manager.Len() != 0 {
+		t.Errorf("Evict = %v, %d accounts left", evicted, manager.Len())
+	}
+
+	if _, err = manager.Api("42"); err != nil || built != 2 {
+		t.Errorf("Api after eviction = %v, built %d", err, built)
+	}
+
+	unknown := &AccountManager{}
+	if _, err = unknown.Api("1"); err == nil {
+		t.Errorf("Api of an unknown account succeeded")
+	}
+}
+
+func TestAccountManagerSignerOutsideLock(t *testing.T) {
+	manager := &AccountManager{Root: "dropbox"}
+	manager.Signer = func(uid string) (RequestSinger, *ApiError) {
+		// a signer calling back into the manager must not deadlock
+		manager.Len()
+		manager.Add("other", &OAuth2{AccessToken: "other"})
+		return &OAuth2{AccessToken: "token-" + uid}, nil
+	}
+
+	done := make(chan *DropboxApi)
+	go func() {
+		api, _ := manager.Api("42")
+		done <- api
+	}()
+	select {
+	case api := <-done:
+		if api == nil || manager.Len() != 2 {
+			t.Errorf("Api = %v with %d accounts", api, manager.Len())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Api deadlocked on a signer calling the manager")
+	}
+}
+
+func TestAccountManagerReleaseAtEOF(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	manager := &AccountManager{Root: "dropbox", MaxConcurrent: 1}
+	api := manager.Add("1", &OAuth2{AccessToken: "a"})
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		resp, err := api.Client.Do(req)
+		if err != nil {
+			cancel()
+			t.Fatalf("request %d: %v", i, err)
+		}
+		// read to the end without closing
+		ioutil.ReadAll(resp.Body)
+		cancel()
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 110; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("110 requests at 100/s took %v", elapsed)
+	}
+
+	slow := newRateLimiter(0.1)
+	slow.wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := slow.wait(ctx); err == nil {
+		t.Errorf("wait ignored the context")
+	}
+}
@@ -0,0 +1,117 @@
+package dropbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// Sender sends a request and returns its response.
+type Sender func(req *http.Request) (*http.Response, *ApiError)
+
+// Middleware decorates a Sender, it can change the request before calling
+// next and look at or replace what next returns.
+type Middleware func(next Sender) Sender
+
+// ResponseObserver is implemented by signers that need to see the responses
+// to the requests they signed.
+type ResponseObserver interface {
+	Observe(req *http.Request, resp *http.Response)
+}
+
+// SignerMiddleware signs requests with signer. Responses are shown to
+// signers implementing ResponseObserver. For OAuth2 tokens, given as such or
+// by a StoreSigner, a 401 becomes an error matching ErrTokenRevoked with the
+// message of Dropbox.
+func SignerMiddleware(signer RequestSinger) Middleware {
+	return func(next Sender) Sender {
+		return func(req *http.Request) (*http.Response, *ApiError) {
+			if err := signer.Sign(req); err != nil {
+				return nil, err
+			}
+
+			resp, err := next(req)
+			if err != nil {
+				return resp, err
+			}
+
+			if observer, ok := signer.(ResponseObserver); ok {
+				observer.Observe(req, resp)
+			}
+
+			if resp.StatusCode == http.StatusUnauthorized && revocable(signer) {
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				upstream := &ApiError{}
+				json.Unmarshal(body, upstream)
+				return nil, tokenRevoked(upstream.ErrorMsg)
+			}
+			return resp, nil
+		}
+	}
+}
+
+// revocable tells whether signer signs with OAuth2 tokens, which Dropbox
+// answers with a 401 once revoked.
+func revocable(signer RequestSinger) bool {
+	switch signer.(type) {
+	case *OAuth2, *StoreSigner:
+		return true
+	}
+	return false
+}
+
+// BeforeSend calls fn with every request before it is sent, a non nil
+// error stops the request.
+func BeforeSend(fn func(req *http.Request) *ApiError) Middleware {
+	return func(next Sender) Sender {
+		return func(req *http.Request) (*http.Response, *ApiError) {
+			if err := fn(req); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
+
+// AfterReceive calls fn with every request and what sending it returned,
+// fn returns what the caller gets.
+func AfterReceive(fn func(req *http.Request, resp *http.Response, err *ApiError) (*http.Response, *ApiError)) Middleware {
+	return func(next Sender) Sender {
+		return func(req *http.Request) (*http.Response, *ApiError) {
+			resp, err := next(req)
+			return fn(req, resp, err)
+		}
+	}
+}
+
+// SetHeader sets a header on every request, such as a tracing header.
+func SetHeader(key, value string) Middleware {
+	return BeforeSend(func(req *http.Request) *ApiError {
+		req.Header.Set(key, value)
+		return nil
+	})
+}
+
+// UserAgent sets the User-Agent header of every request.
+func UserAgent(userAgent string) Middleware {
+	return SetHeader("User-Agent", userAgent)
+}
+
+// RequestId sets a new id in the X-Request-Id header of every request,
+// newId defaults to random hex strings.
+func RequestId(newId func() string) Middleware {
+	return BeforeSend(func(req *http.Request) *ApiError {
+		id := ""
+		if newId != nil {
+			id = newId()
+		} else {
+			var err *ApiError
+			if id, err = randomState(); err != nil {
+				return err
+			}
+		}
+		req.Header.Set("X-Request-Id", id)
+		return nil
+	})
+}
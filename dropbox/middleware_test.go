@@ -0,0 +1,65 @@
+package dropbox
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewares(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"ua": %q, "id": %q, "auth": %q, "order": %q}`, r.Header.Get("User-Agent"),
+			r.Header.Get("X-Request-Id"), r.Header.Get("Authorization"), r.Header.Get("X-Order"))
+	}))
+	defer server.Close()
+
+	order := func(name string) Middleware {
+		return BeforeSend(func(req *http.Request) *ApiError {
+			req.Header.Set("X-Order", req.Header.Get("X-Order")+name)
+			return nil
+		})
+	}
+
+	audited := []int{}
+	api := &DropboxApi{Signer: &OAuth2{AccessToken: "tok"}, Middlewares: []Middleware{
+		order("a"),
+		UserAgent("godropbox-test"),
+		RequestId(func() string { return "req-1" }),
+		AfterReceive(func(req *http.Request, resp *http.Response, err *ApiError) (*http.Response, *ApiError) {
+			if resp != nil {
+				audited = append(audited, resp.StatusCode)
+			}
+			return resp, err
+		}),
+		order("b"),
+	}}
+
+	res := make(map[string]string)
+	if err := api.jsonReponseByGet(server.URL, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res["ua"] != "godropbox-test" || res["id"] != "req-1" || res["auth"] != "Bearer tok" || res["order"] != "ab" {
+		t.Errorf("server saw %v", res)
+	}
+	if len(audited) != 1 || audited[0] != http.StatusOK {
+		t.Errorf("audited %v", audited)
+	}
+}
+
+func TestBeforeSendStops(t *testing.T) {
+	stop := &ApiError{Code: -1, ErrorMsg: "injected"}
+	api := &DropboxApi{Middlewares: []Middleware{
+		BeforeSend(func(req *http.Request) *ApiError { return stop }),
+	}}
+
+	res := make(map[string]string)
+	if err := api.jsonReponseByGet("http://127.0.0.1:1/never", &res); err != stop {
+		t.Errorf("error = %v", err)
+	}
+
+	if err := (&DropboxApi{}).jsonReponseByGet("http://127.0.0.1:1/never", &res); err == nil {
+		t.Errorf("request without signer nor middleware succeeded")
+	}
+}
@@ -0,0 +1,199 @@
+package dropbox
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OAuth1PlainText = "PLAINTEXT"
+	OAuth1HmacSha1  = "HMAC-SHA1"
+)
+
+// OAuth1 signs requests with an OAuth1 token pair, for apps authorized
+// before OAuth2. Method is OAuth1PlainText when empty.
+type OAuth1 struct {
+	AppKey       string
+	AppSecret    string
+	AccessToken  string
+	AccessSecret string
+	Uid          string
+	Method       string
+}
+
+func (oauth *OAuth1) Sign(req *http.Request) *ApiError {
+	nonce, err := randomState()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Authorization", oauth.authorization(req, nonce, timestamp))
+	return nil
+}
+
+// authorization builds the Authorization header, see RFC 5849.
+func (oauth *OAuth1) authorization(req *http.Request, nonce, timestamp string) string {
+	method := oauth.Method
+	if len(method) == 0 {
+		method = OAuth1PlainText
+	}
+
+	params := [][2]string{
+		{"oauth_consumer_key", oauth.AppKey},
+		{"oauth_signature_method", method},
+		{"oauth_version", "1.0"},
+	}
+	if len(oauth.AccessToken) > 0 {
+		params = append(params, [2]string{"oauth_token", oauth.AccessToken})
+	}
+
+	key := oauthEscape(oauth.AppSecret) + "&" + oauthEscape(oauth.AccessSecret)
+	signature := key
+	if method == OAuth1HmacSha1 {
+		params = append(params, [2]string{"oauth_nonce", nonce}, [2]string{"oauth_timestamp", timestamp})
+
+		mac := hmac.New(sha1.New, []byte(key))
+		mac.Write([]byte(oauthBaseString(req, params)))
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	params = append(params, [2]string{"oauth_signature", signature})
+
+	header := []string{}
+	for _, param := range params {
+		header = append(header, fmt.Sprintf(`%s="%s"`, param[0], oauthEscape(param[1])))
+	}
+	return "OAuth " + strings.Join(header, ", ")
+}
+
+// oauthBaseString is the signature base string of RFC 5849 3.4.1. The
+// parameters sort by encoded name, then encoded value, not as "name=value"
+// strings: "a-b=1" sorts before "a=2" but the name "a" before "a-b".
+func oauthBaseString(req *http.Request, oauthParams [][2]string) string {
+	encoded := [][2]string{}
+	for k, values := range req.URL.Query() {
+		for _, v := range values {
+			encoded = append(encoded, [2]string{oauthEscape(k), oauthEscape(v)})
+		}
+	}
+	for _, param := range oauthParams {
+		encoded = append(encoded, [2]string{oauthEscape(param[0]), oauthEscape(param[1])})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		if encoded[i][0] != encoded[j][0] {
+			return encoded[i][0] < encoded[j][0]
+		}
+		return encoded[i][1] < encoded[j][1]
+	})
+
+	params := []string{}
+	for _, param := range encoded {
+		params = append(params, param[0]+"="+param[1])
+	}
+
+	host := strings.ToLower(req.URL.Host)
+	if (req.URL.Scheme == "https" && strings.HasSuffix(host, ":443")) ||
+		(req.URL.Scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	baseUrl := strings.ToLower(req.URL.Scheme) + "://" + host + req.URL.EscapedPath()
+
+	return strings.ToUpper(req.Method) + "&" + oauthEscape(baseUrl) + "&" + oauthEscape(strings.Join(params, "&"))
+}
+
+func oauthEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// OAuth1Token is a request or access token pair.
+type OAuth1Token struct {
+	Token  string
+	Secret string
+	Uid    string // set on access tokens
+}
+
+// OAuth1Config is an app registered with Dropbox, used to run the OAuth1
+// authorization: RequestToken, then the user visits AuthorizeURL, then
+// AccessToken.
+type OAuth1Config struct {
+	AppKey    string
+	AppSecret string
+	Method    string
+
+	// Client sends the token requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+func (config *OAuth1Config) RequestToken() (*OAuth1Token, *ApiError) {
+	signer := &OAuth1{AppKey: config.AppKey, AppSecret: config.AppSecret, Method: config.Method}
+	return postOAuth1TokenRequest(config.Client, apiUrls["oauth/request_token"], signer)
+}
+
+func (config *OAuth1Config) AuthorizeURL(requestToken *OAuth1Token, callback string) string {
+	values := url.Values{}
+	values.Add("oauth_token", requestToken.Token)
+	if len(callback) > 0 {
+		values.Add("oauth_callback", callback)
+	}
+	return fmt.Sprintf("%s?%s", apiUrls["oauth/authorize"], values.Encode())
+}
+
+// AccessToken trades an authorized request token for a signer holding the
+// access token.
+func (config *OAuth1Config) AccessToken(requestToken *OAuth1Token) (*OAuth1, *ApiError) {
+	signer := &OAuth1{AppKey: config.AppKey, AppSecret: config.AppSecret, Method: config.Method,
+		AccessToken: requestToken.Token, AccessSecret: requestToken.Secret}
+
+	token, err := postOAuth1TokenRequest(config.Client, apiUrls["oauth/access_token"], signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuth1{AppKey: config.AppKey, AppSecret: config.AppSecret, Method: config.Method,
+		AccessToken: token.Token, AccessSecret: token.Secret, Uid: token.Uid}, nil
+}
+
+func postOAuth1TokenRequest(client *http.Client, tokenUrl string, signer *OAuth1) (*OAuth1Token, *ApiError) {
+	req, httperr := http.NewRequest("POST", tokenUrl, nil)
+	if httperr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: httperr.Error()}
+	}
+	if err := signer.Sign(req); err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, httperr := client.Do(req)
+	if httperr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: httperr.Error()}
+	}
+	defer resp.Body.Close()
+
+	body, ioerr := ioutil.ReadAll(resp.Body)
+	if ioerr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: ioerr.Error()}
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := &ApiError{Code: resp.StatusCode}
+		json.Unmarshal(body, msg)
+		return nil, msg
+	}
+
+	values, parseErr := url.ParseQuery(string(body))
+	if parseErr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: parseErr.Error()}
+	}
+	return &OAuth1Token{Token: values.Get("oauth_token"), Secret: values.Get("oauth_token_secret"), Uid: values.Get("uid")}, nil
+}
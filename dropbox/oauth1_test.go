@@ -0,0 +1,94 @@
+package dropbox
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOAuth1HmacSha1(t *testing.T) {
+	// the example from the OAuth 1.0 specification, appendix A.5.
+	oauth := &OAuth1{AppKey: "dpf43f3p2l4k3l03", AppSecret: "kd94hf93k423kf44",
+		AccessToken: "nnch734d00sl2jdk", AccessSecret: "pfkkdhi9sl3r4s00", Method: OAuth1HmacSha1}
+
+	req, _ := http.NewRequest("GET", "http://photos.example.net/photos?file=vacation.jpg&size=original", nil)
+	header := oauth.authorization(req, "kllo9940pd9333jh", "1191242096")
+
+	if !strings.Contains(header, `oauth_signature="tR3%2BTy81lMeYAr%2FFid0kMTYa%2FWM%3D"`) {
+		t.Errorf("Authorization = %s", header)
+	}
+}
+
+func TestOAuthBaseStringOrder(t *testing.T) {
+	// "a" is a prefix of "a-b", sorting "name=value" strings would put a-b first.
+	req, _ := http.NewRequest("POST", "https://Api.Dropbox.com:443/1/r?c=2&a-b=1&c=10&a=2", nil)
+	base := oauthBaseString(req, [][2]string{{"oauth_token", "t k"}})
+
+	want := "POST&https%3A%2F%2Fapi.dropbox.com%2F1%2Fr&a%3D2%26a-b%3D1%26c%3D10%26c%3D2%26oauth_token%3Dt%2520k"
+	if base != want {
+		t.Errorf("base string = %s\nwant %s", base, want)
+	}
+}
+
+func TestOAuth1PlainText(t *testing.T) {
+	oauth := &OAuth1{AppKey: "key", AppSecret: "s&c", AccessToken: "tok", AccessSecret: "ts"}
+
+	req, _ := http.NewRequest("GET", "https://api.dropbox.com/1/account/info", nil)
+	if err := oauth.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+
+	header := req.Header.Get("Authorization")
+	for _, want := range []string{`oauth_signature_method="PLAINTEXT"`, `oauth_consumer_key="key"`,
+		`oauth_token="tok"`, `oauth_signature="s%2526c%26ts"`} {
+		if !strings.Contains(header, want) {
+			t.Errorf("Authorization %s lacks %s", header, want)
+		}
+	}
+}
+
+func TestPostOAuth1TokenRequest(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), `oauth_token="req"`) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "Unauthorized"}`)
+			return
+		}
+		fmt.Fprint(w, "oauth_token_secret=sec&oauth_token=acc&uid=42")
+	}))
+	defer server.Close()
+
+	token, err := postOAuth1TokenRequest(server.Client(), server.URL, &OAuth1{AppKey: "key", AccessToken: "req"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "acc" || token.Secret != "sec" || token.Uid != "42" {
+		t.Errorf("token = %v", token)
+	}
+
+	// the certificate of the server is only trusted by its client
+	if _, err = postOAuth1TokenRequest(nil, server.URL, &OAuth1{AppKey: "key", AccessToken: "req"}); err == nil || err.Code != -1 {
+		t.Errorf("the default client got %v", err)
+	}
+	if _, err = postOAuth1TokenRequest(server.Client(), server.URL, &OAuth1{AppKey: "key"}); err == nil || err.Code != http.StatusUnauthorized {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestOAuth1Unauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "Invalid signature."}`)
+	}))
+	defer server.Close()
+
+	api := &DropboxApi{Signer: &OAuth1{AppKey: "key", AccessToken: "acc"}, Root: "dropbox"}
+	res := make(map[string]interface{})
+	err := api.jsonReponseByGet(server.URL, &res)
+	if err == nil || errors.Is(err, ErrTokenRevoked) || err.Code != http.StatusUnauthorized {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -1,8 +1,12 @@
 package dropbox
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 type OAuth2 struct {
@@ -12,7 +16,112 @@ type OAuth2 struct {
 }
 
 func (oauth *OAuth2) Sign(req *http.Request) *ApiError {
-	authHeader := fmt.Sprintf("Bearer %s", oauth.AccessToken)
-	req.Header.Add("Authorization", authHeader)
+	req.Header.Add("Authorization", oauth.authorization())
 	return nil
 }
+
+func (oauth *OAuth2) authorization() string {
+	return fmt.Sprintf("Bearer %s", oauth.AccessToken)
+}
+
+// signed tells whether req was signed with this token.
+func (oauth *OAuth2) signed(req *http.Request) bool {
+	return req.Header.Get("Authorization") == oauth.authorization()
+}
+
+// OAuth2Config is an app registered with Dropbox, used to run the
+// authorization code flow.
+type OAuth2Config struct {
+	AppKey      string
+	AppSecret   string
+	RedirectUri string // optional, must be one registered for the app
+
+	// Client sends the token requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+type oauth2Token struct {
+	Access_token string
+	Token_type   string
+	Uid          string
+}
+
+// AuthCodeURL returns the url to send the user to. After the user allows the
+// app, Dropbox redirects to RedirectUri with the code and state as query
+// parameters, or shows the code when RedirectUri is empty.
+func (config *OAuth2Config) AuthCodeURL(state string) string {
+	values := url.Values{}
+	values.Add("response_type", "code")
+	values.Add("client_id", config.AppKey)
+	if len(config.RedirectUri) > 0 {
+		values.Add("redirect_uri", config.RedirectUri)
+	}
+	if len(state) > 0 {
+		values.Add("state", state)
+	}
+
+	return fmt.Sprintf("%s?%s", apiUrls["authorize-url"], values.Encode())
+}
+
+// Exchange trades the code got from the authorization for an access token.
+func (config *OAuth2Config) Exchange(code string) (*OAuth2, *ApiError) {
+	values := url.Values{}
+	values.Add("code", code)
+	values.Add("grant_type", "authorization_code")
+	values.Add("client_id", config.AppKey)
+	values.Add("client_secret", config.AppSecret)
+	if len(config.RedirectUri) > 0 {
+		values.Add("redirect_uri", config.RedirectUri)
+	}
+
+	return postTokenRequest(config.Client, apiUrls["token-url"], values)
+}
+
+func postTokenRequest(client *http.Client, tokenUrl string, values url.Values) (*OAuth2, *ApiError) {
+	req, httperr := http.NewRequest("POST", tokenUrl, strings.NewReader(values.Encode()))
+	if httperr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: httperr.Error()}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, httperr := client.Do(req)
+	if httperr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: httperr.Error()}
+	}
+	defer resp.Body.Close()
+
+	body, ioerr := ioutil.ReadAll(resp.Body)
+	if ioerr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: ioerr.Error()}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ApiError{Code: resp.StatusCode, ErrorMsg: oauth2ErrorMsg(body, resp.Status)}
+	}
+
+	token := &oauth2Token{}
+	if jsonerr := json.Unmarshal(body, token); jsonerr != nil {
+		return nil, &ApiError{Code: -1, ErrorMsg: jsonerr.Error()}
+	}
+	return &OAuth2{AccessToken: token.Access_token, TokenType: token.Token_type, Uid: token.Uid}, nil
+}
+
+// oauth2ErrorMsg reads an OAuth2 error body, {"error": ..., "error_description": ...}.
+func oauth2ErrorMsg(body []byte, status string) string {
+	oauthErr := struct {
+		Error             string
+		Error_description string
+	}{}
+	json.Unmarshal(body, &oauthErr)
+
+	switch {
+	case len(oauthErr.Error_description) > 0:
+		return fmt.Sprintf("%s: %s", oauthErr.Error, oauthErr.Error_description)
+	case len(oauthErr.Error) > 0:
+		return oauthErr.Error
+	}
+	return status
+}
@@ -0,0 +1,144 @@
+package dropbox
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthCodeURL(t *testing.T) {
+	config := &OAuth2Config{AppKey: "key&x=1", RedirectUri: "https://example.com/cb?a=b"}
+
+	u, err := url.Parse(config.AuthCodeURL("st ate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != "key&x=1" ||
+		query.Get("redirect_uri") != "https://example.com/cb?a=b" || query.Get("state") != "st ate" {
+		t.Errorf("AuthCodeURL query = %v", query)
+	}
+	if len(query["x"]) > 0 {
+		t.Errorf("app key is not escaped: %s", u)
+	}
+}
+
+func TestPostTokenRequest(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good" || r.Form.Get("client_secret") != "s&cret" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant", "error_description": "bad code"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "tok", "token_type": "bearer", "uid": "42"}`)
+	}))
+	defer server.Close()
+
+	values := url.Values{}
+	values.Add("code", "good")
+	values.Add("client_secret", "s&cret")
+	token, err := postTokenRequest(server.Client(), server.URL, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "tok" || token.TokenType != "bearer" || token.Uid != "42" {
+		t.Errorf("token = %v", token)
+	}
+
+	// the certificate of the server is only trusted by its client
+	if _, err = postTokenRequest(nil, server.URL, values); err == nil || err.Code != -1 {
+		t.Errorf("the default client got %v", err)
+	}
+
+	values.Set("code", "bad")
+	if _, err = postTokenRequest(server.Client(), server.URL, values); err == nil || err.Code != http.StatusBadRequest ||
+		err.ErrorMsg != "invalid_grant: bad code" {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestAuthorizeLoopbackToken(t *testing.T) {
+	config := &OAuth2Config{AppKey: "key"}
+
+	open := func(authUrl string) error {
+		u, _ := url.Parse(authUrl)
+		query := u.Query()
+		if query.Get("response_type") != "token" {
+			t.Errorf("response_type = %q", query.Get("response_type"))
+		}
+		redirect := query.Get("redirect_uri")
+
+		resp, err := http.Get(redirect)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		resp, err = http.Get(redirect + "?state=wrong&access_token=evil")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("wrong state status = %d", resp.StatusCode)
+		}
+
+		resp, err = http.Get(redirect + "?access_token=tok&token_type=bearer&uid=7&state=" + query.Get("state"))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	oauth2, err := config.AuthorizeLoopback("", open, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oauth2.AccessToken != "tok" || oauth2.Uid != "7" || oauth2.TokenType != "bearer" {
+		t.Errorf("oauth2 = %v", oauth2)
+	}
+}
+
+func TestAuthorizeLoopbackDenied(t *testing.T) {
+	config := &OAuth2Config{AppKey: "key"}
+
+	open := func(authUrl string) error {
+		u, _ := url.Parse(authUrl)
+		query := u.Query()
+		resp, err := http.Get(query.Get("redirect_uri") + "?error=access_denied&state=" + query.Get("state"))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if _, err := config.AuthorizeLoopback("", open, time.Second); err == nil || err.ErrorMsg != "access_denied" {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestOAuth2TokenRevoked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "The given OAuth 2 access token doesn't exist or has expired."}`)
+	}))
+	defer server.Close()
+
+	api := &DropboxApi{Signer: &OAuth2{AccessToken: "revoked"}, Root: "dropbox"}
+	res := make(map[string]interface{})
+	err := api.jsonReponseByGet(server.URL, &res)
+	if err == nil || !errors.Is(err, ErrTokenRevoked) || err.Code != http.StatusUnauthorized ||
+		err.ErrorMsg != "The given OAuth 2 access token doesn't exist or has expired." {
+		t.Errorf("error = %v", err)
+	}
+	if again := api.jsonReponseByGet(server.URL, &res); again == err {
+		t.Error("the revoked token errors are shared")
+	}
+}
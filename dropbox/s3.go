@@ -0,0 +1,882 @@
+package dropbox
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Gateway serves a subset of the S3 REST API over a Dropbox folder: buckets
+// are the folders in Dir and keys are paths below them. Only path style
+// addressing is supported and requests are not authenticated, put the
+// gateway behind a handler checking them.
+//
+// Multipart uploads go to Dropbox chunked uploads. Dropbox takes the chunks in
+// order, so parts are kept in TempDir until all the parts before them arrived.
+type S3Gateway struct {
+	Api     *DropboxApi
+	Root    string // Api.Root when empty
+	Dir     string
+	TempDir string // os.TempDir() when empty
+
+	mu      sync.Mutex
+	uploads map[string]*s3Upload
+}
+
+type s3Upload struct {
+	mu       sync.Mutex
+	bucket   string
+	key      string
+	uploadId string // the Dropbox chunked upload, empty until the first part is sent
+	offset   int
+	next     int             // the part to send next
+	sent     []int           // part numbers sent, in order
+	etags    map[int]string  // of all parts received
+	parts    map[int]*s3Part // received but not sent yet
+}
+
+type s3Part struct {
+	file string
+	size int64
+}
+
+func (gateway *S3Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, ok := splitS3Path(r.URL.Path)
+	query := r.URL.Query()
+
+	switch {
+	case !ok:
+		s3Error(w, r, http.StatusBadRequest, "InvalidURI", "")
+	case len(bucket) == 0 && r.Method == "GET":
+		gateway.listBuckets(w, r)
+	case len(bucket) == 0:
+		s3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "")
+	case len(key) == 0:
+		switch r.Method {
+		case "GET":
+			gateway.listObjects(w, r, bucket)
+		case "HEAD":
+			if !gateway.bucketExists(w, r, bucket) {
+				return
+			}
+		case "PUT":
+			if _, err := gateway.Api.CreateFolder_(gateway.root(), gateway.remote(bucket, "")); err != nil {
+				if err.Code == http.StatusForbidden {
+					s3Error(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", "")
+					return
+				}
+				gateway.s3ApiError(w, r, err, "NoSuchBucket")
+			}
+		case "DELETE":
+			gateway.deleteBucket(w, r, bucket)
+		default:
+			s3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "")
+		}
+	default:
+		switch {
+		case r.Method == "GET" || r.Method == "HEAD":
+			gateway.getObject(w, r, bucket, key)
+		case r.Method == "PUT" && len(query.Get("uploadId")) > 0:
+			gateway.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+		case r.Method == "PUT" && len(r.Header.Get("x-amz-copy-source")) > 0:
+			gateway.copyObject(w, r, bucket, key)
+		case r.Method == "PUT":
+			gateway.putObject(w, r, bucket, key)
+		case r.Method == "POST" && hasQuery(query, "uploads"):
+			gateway.createMultipartUpload(w, r, bucket, key)
+		case r.Method == "POST" && len(query.Get("uploadId")) > 0:
+			gateway.completeMultipartUpload(w, r, query.Get("uploadId"))
+		case r.Method == "DELETE" && len(query.Get("uploadId")) > 0:
+			gateway.abortMultipartUpload(w, r, query.Get("uploadId"))
+		case r.Method == "DELETE":
+			gateway.deleteObject(w, r, bucket, key)
+		default:
+			s3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "")
+		}
+	}
+}
+
+func hasQuery(query url.Values, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+// splitS3Path returns the bucket and the key of urlPath, not ok when a "."
+// or ".." segment would lead out of Dir.
+func splitS3Path(urlPath string) (string, string, bool) {
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	if !cleanS3Name(urlPath) {
+		return "", "", false
+	}
+	if i := strings.Index(urlPath, "/"); i >= 0 {
+		return urlPath[:i], urlPath[i+1:], true
+	}
+	return urlPath, "", true
+}
+
+// cleanS3Name tells whether name has no "." or ".." segment.
+func cleanS3Name(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (gateway *S3Gateway) root() string {
+	if len(gateway.Root) > 0 {
+		return gateway.Root
+	}
+	return gateway.Api.Root
+}
+
+func (gateway *S3Gateway) remote(bucket, key string) string {
+	return path.Join("/", gateway.Dir, bucket, key)
+}
+
+// bucketExists answers NoSuchBucket when bucket is not a folder.
+func (gateway *S3Gateway) bucketExists(w http.ResponseWriter, r *http.Request, bucket string) bool {
+	metadata, err := gateway.Api.GetFileMetadata_(gateway.root(), gateway.remote(bucket, ""), 1, "", false, false, "")
+	if err == nil && !metadata.Is_dir {
+		err = &ApiError{Code: http.StatusNotFound, ErrorMsg: "not a folder"}
+	}
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchBucket")
+		return false
+	}
+	return true
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+func (gateway *S3Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	metadata, err := gateway.Api.GetFileMetadata_(gateway.root(), gateway.remote("", ""), 25000, "", true, false, "")
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchBucket")
+		return
+	}
+
+	result := &s3ListAllMyBucketsResult{Buckets: []s3Bucket{}}
+	for _, content := range metadata.Contents {
+		if content.Is_dir {
+			result.Buckets = append(result.Buckets, s3Bucket{Name: path.Base(content.Path), CreationDate: s3Time(content.Modified)})
+		}
+	}
+	writeS3Xml(w, http.StatusOK, result)
+}
+
+func (gateway *S3Gateway) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	metadata, err := gateway.Api.GetFileMetadata_(gateway.root(), gateway.remote(bucket, ""), 1, "", true, false, "")
+	if err == nil && len(metadata.Contents) > 0 {
+		s3Error(w, r, http.StatusConflict, "BucketNotEmpty", "")
+		return
+	}
+	if err == nil {
+		_, err = gateway.Api.Delete_(gateway.root(), gateway.remote(bucket, ""))
+	}
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchBucket")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// s3ListItem is an object, or a common prefix when content is nil.
+type s3ListItem struct {
+	key     string
+	content *Content
+}
+
+// listObjects is ListObjectsV2. With the "/" delimiter only the folder
+// holding the prefix is listed, otherwise the tree below it is walked.
+func (gateway *S3Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		s3Error(w, r, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported")
+		return
+	}
+
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := 1000
+	if v := query.Get("max-keys"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys")
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); len(token) > 0 {
+		decoded, err := base64.URLEncoding.DecodeString(token)
+		if err != nil {
+			s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "continuation-token")
+			return
+		}
+		after = string(decoded)
+	}
+
+	bucketPath := cleanRemotePath(gateway.remote(bucket, ""))
+	folder := prefix[:strings.LastIndex(prefix, "/")+1]
+	if !cleanS3Name(folder) {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "prefix")
+		return
+	}
+
+	items := []*s3ListItem{}
+	add := func(content *Content) {
+		rel, ok := relRemotePath(bucketPath, content.Path)
+		if !ok || len(rel) == 0 || !hasPrefixFold(rel, prefix) {
+			return
+		}
+		if content.Is_dir {
+			items = append(items, &s3ListItem{key: rel + "/"})
+		} else {
+			items = append(items, &s3ListItem{key: rel, content: content})
+		}
+	}
+
+	var err *ApiError
+	if delimiter == "/" {
+		var metadata *PathMetadata
+		if metadata, err = gateway.Api.GetFileMetadata_(gateway.root(), path.Join(bucketPath, folder), 25000, "", true, false, ""); err == nil {
+			for i := range metadata.Contents {
+				add(&metadata.Contents[i])
+			}
+		}
+	} else {
+		err = gateway.Api.Walk(gateway.root(), path.Join(bucketPath, folder), nil, func(p string, metadata *PathMetadata, err *ApiError) *ApiError {
+			if err != nil {
+				return err
+			}
+			if !metadata.Is_dir {
+				add(&metadata.Content)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		if err.Code == http.StatusNotFound && len(folder) > 0 && !gateway.bucketExists(w, r, bucket) {
+			return
+		}
+		if err.Code != http.StatusNotFound || len(folder) == 0 {
+			gateway.s3ApiError(w, r, err, "NoSuchBucket")
+			return
+		}
+	}
+
+	// group keys holding the delimiter after the prefix, folders listed
+	// above are grouped the same way
+	grouped := items[:0]
+	seen := map[string]bool{}
+	for _, item := range items {
+		if len(delimiter) > 0 {
+			if i := strings.Index(item.key[len(prefix):], delimiter); i >= 0 {
+				common := item.key[:len(prefix)+i+len(delimiter)]
+				if seen[common] {
+					continue
+				}
+				seen[common] = true
+				item = &s3ListItem{key: common}
+			}
+		}
+		if item.content != nil || len(delimiter) > 0 {
+			grouped = append(grouped, item)
+		}
+	}
+	items = grouped
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+
+	result := &s3ListBucketResult{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys,
+		ContinuationToken: query.Get("continuation-token"), StartAfter: query.Get("start-after")}
+	encode := func(key string) string { return key }
+	if query.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		encode = url.PathEscape
+	}
+
+	for _, item := range items {
+		if item.key <= after {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.KeyCount++
+		if item.content == nil {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: encode(item.key)})
+		} else {
+			result.Contents = append(result.Contents, s3Object{Key: encode(item.key), LastModified: s3Time(item.content.Modified),
+				ETag: `"` + item.content.Rev + `"`, Size: item.content.Bytes, StorageClass: "STANDARD"})
+		}
+		after = item.key
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(after))
+	}
+
+	writeS3Xml(w, http.StatusOK, result)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func (gateway *S3Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	root := gateway.root()
+	metadata, err := gateway.Api.GetFileMetadata_(root, gateway.remote(bucket, key), 1, "", false, false, "")
+	if err == nil && metadata.Is_dir && !strings.HasSuffix(key, "/") {
+		err = &ApiError{Code: http.StatusNotFound, ErrorMsg: "is a folder"}
+	}
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchKey")
+		return
+	}
+
+	header := w.Header()
+	etag := `"` + metadata.Rev + `"`
+	modified, _ := parseDropboxTime(metadata.Modified)
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+
+	if match := r.Header.Get("If-Match"); len(match) > 0 && match != etag && match != "*" {
+		s3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "If-Match")
+		return
+	}
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := metadata.Mime_type
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	// the marker object of a folder is empty
+	if metadata.Is_dir {
+		header.Set("Content-Length", "0")
+		return
+	}
+
+	size := int64(metadata.Bytes)
+	offset, length, status := int64(0), size, http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); len(rangeHeader) > 0 {
+		start, n, rangeErr := ParseRange(rangeHeader, size)
+		switch rangeErr {
+		case nil:
+			offset, length, status = start, n, http.StatusPartialContent
+			header.Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(start+n-1, 10)+"/"+strconv.FormatInt(size, 10))
+		case ErrRangeUnsatisfiable:
+			s3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "")
+			return
+		}
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+
+	if r.Method == "HEAD" {
+		w.WriteHeader(status)
+		return
+	}
+
+	reader, err := gateway.Api.GetFileRange_(root, metadata.Path, metadata.Rev, offset, length)
+	if err != nil {
+		header.Del("Content-Length")
+		header.Del("Content-Range")
+		gateway.s3ApiError(w, r, err, "NoSuchKey")
+		return
+	}
+	defer reader.Close()
+
+	w.WriteHeader(status)
+	io.Copy(w, reader)
+}
+
+// putObject stores the body, a key ending with a slash is a folder.
+func (gateway *S3Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !gateway.bucketExists(w, r, bucket) {
+		return
+	}
+
+	if strings.HasSuffix(key, "/") {
+		_, err := gateway.Api.CreateFolder_(gateway.root(), gateway.remote(bucket, key))
+		if err != nil && err.Code != http.StatusForbidden {
+			gateway.s3ApiError(w, r, err, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", `""`)
+		return
+	}
+
+	metadata, err := gateway.Api.PutFile(s3Body(r), gateway.root(), gateway.remote(bucket, key), "", true)
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchKey")
+		return
+	}
+	w.Header().Set("ETag", `"`+metadata.Rev+`"`)
+}
+
+// deleteObject removes a file, or a folder when it is empty. Removing what
+// does not exist succeeds.
+func (gateway *S3Gateway) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	root, remote := gateway.root(), gateway.remote(bucket, key)
+
+	metadata, err := gateway.Api.GetFileMetadata_(root, remote, 1, "", true, false, "")
+	if err == nil && metadata.Is_dir && (!strings.HasSuffix(key, "/") || len(metadata.Contents) > 0) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err == nil {
+		_, err = gateway.Api.Delete_(root, remote)
+	}
+	if err != nil && err.Code != http.StatusNotFound {
+		gateway.s3ApiError(w, r, err, "NoSuchKey")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	LastModified string
+	ETag         string
+}
+
+func (gateway *S3Gateway) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	source, unescapeErr := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if i := strings.Index(source, "?"); i >= 0 {
+		source = source[:i]
+	}
+	fromBucket, fromKey, ok := splitS3Path("/" + strings.TrimPrefix(source, "/"))
+	if unescapeErr != nil || !ok || len(fromBucket) == 0 || len(fromKey) == 0 {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "x-amz-copy-source")
+		return
+	}
+	if !gateway.bucketExists(w, r, bucket) {
+		return
+	}
+
+	root, from, to := gateway.root(), gateway.remote(fromBucket, fromKey), gateway.remote(bucket, key)
+	if from == to {
+		s3Error(w, r, http.StatusBadRequest, "InvalidRequest", "copying an object onto itself is not supported")
+		return
+	}
+
+	// nothing is touched for a missing source
+	if _, err := gateway.Api.GetFileMetadata_(root, from, 1, "", false, false, ""); err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchKey")
+		return
+	}
+
+	// Dropbox does not copy over a file
+	var metadata *PathMetadata
+	var err *ApiError
+	if _, err = gateway.Api.GetFileMetadata_(root, to, 1, "", false, false, ""); err == nil {
+		metadata, err = gateway.Api.replace(root, from, to, true)
+	} else {
+		metadata, err = gateway.Api.Copy_(root, from, to, "")
+	}
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchKey")
+		return
+	}
+	writeS3Xml(w, http.StatusOK, &s3CopyObjectResult{LastModified: s3Time(metadata.Modified), ETag: `"` + metadata.Rev + `"`})
+}
+
+type s3InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+func (gateway *S3Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !gateway.bucketExists(w, r, bucket) {
+		return
+	}
+
+	id, err := randomState()
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "")
+		return
+	}
+
+	gateway.mu.Lock()
+	if gateway.uploads == nil {
+		gateway.uploads = make(map[string]*s3Upload)
+	}
+	gateway.uploads[id] = &s3Upload{bucket: bucket, key: key, next: 1,
+		etags: make(map[int]string), parts: make(map[int]*s3Part)}
+	gateway.mu.Unlock()
+
+	writeS3Xml(w, http.StatusOK, &s3InitiateMultipartUploadResult{Bucket: bucket, Key: key, UploadId: id})
+}
+
+func (gateway *S3Gateway) upload(w http.ResponseWriter, r *http.Request, id string) *s3Upload {
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+
+	upload := gateway.uploads[id]
+	if upload == nil {
+		s3Error(w, r, http.StatusNotFound, "NoSuchUpload", "")
+	}
+	return upload
+}
+
+func (gateway *S3Gateway) forget(id string) {
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+	delete(gateway.uploads, id)
+}
+
+func (gateway *S3Gateway) uploadPart(w http.ResponseWriter, r *http.Request, id, partNumber string) {
+	number, converr := strconv.Atoi(partNumber)
+	if converr != nil || number < 1 || number > 10000 {
+		s3Error(w, r, http.StatusBadRequest, "InvalidArgument", "partNumber")
+		return
+	}
+	upload := gateway.upload(w, r, id)
+	if upload == nil {
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	if number < upload.next {
+		s3Error(w, r, http.StatusBadRequest, "InvalidPart", "the part was already sent to Dropbox")
+		return
+	}
+
+	temp, ioerr := ioutil.TempFile(gateway.TempDir, "dropbox-part-")
+	if ioerr != nil {
+		gateway.s3ApiError(w, r, gateway.Api.toApiError(ioerr), "")
+		return
+	}
+	hash := md5.New()
+	size, ioerr := io.Copy(io.MultiWriter(temp, hash), s3Body(r))
+	temp.Close()
+	if ioerr != nil {
+		os.Remove(temp.Name())
+		gateway.s3ApiError(w, r, gateway.Api.toApiError(ioerr), "")
+		return
+	}
+
+	if old := upload.parts[number]; old != nil {
+		os.Remove(old.file)
+	}
+	upload.parts[number] = &s3Part{file: temp.Name(), size: size}
+	upload.etags[number] = `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+
+	for upload.parts[upload.next] != nil {
+		if err := gateway.sendPart(upload, upload.next); err != nil {
+			gateway.s3ApiError(w, r, err, "")
+			return
+		}
+		upload.next++
+	}
+	w.Header().Set("ETag", upload.etags[number])
+}
+
+// sendPart appends a received part to the Dropbox chunked upload.
+func (gateway *S3Gateway) sendPart(upload *s3Upload, number int) *ApiError {
+	part := upload.parts[number]
+	file, ioerr := os.Open(part.file)
+	if ioerr != nil {
+		return gateway.Api.toApiError(ioerr)
+	}
+	defer file.Close()
+
+	res, err := gateway.Api.ChunkedUpload(file, part.size, upload.uploadId, upload.offset)
+	if err != nil {
+		return err
+	}
+	upload.uploadId, upload.offset = res.Upload_id, res.Offset
+	upload.sent = append(upload.sent, number)
+
+	os.Remove(part.file)
+	delete(upload.parts, number)
+	return nil
+}
+
+type s3CompleteMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// completeMultipartUpload sends the parts still held, in the order listed,
+// then commits the chunked upload.
+func (gateway *S3Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, id string) {
+	complete := &s3CompleteMultipartUpload{}
+	if err := xml.NewDecoder(r.Body).Decode(complete); err != nil || len(complete.Parts) == 0 {
+		s3Error(w, r, http.StatusBadRequest, "MalformedXML", "")
+		return
+	}
+	upload := gateway.upload(w, r, id)
+	if upload == nil {
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	for i, part := range complete.Parts {
+		if i > 0 && part.PartNumber <= complete.Parts[i-1].PartNumber {
+			s3Error(w, r, http.StatusBadRequest, "InvalidPartOrder", "")
+			return
+		}
+		etag, ok := upload.etags[part.PartNumber]
+		if !ok || strings.Trim(etag, `"`) != strings.Trim(part.ETag, `"`) {
+			s3Error(w, r, http.StatusBadRequest, "InvalidPart", strconv.Itoa(part.PartNumber))
+			return
+		}
+		// the parts sent already must come first
+		if i < len(upload.sent) && upload.sent[i] != part.PartNumber {
+			s3Error(w, r, http.StatusBadRequest, "InvalidPart", strconv.Itoa(upload.sent[i]))
+			return
+		}
+	}
+	if len(complete.Parts) < len(upload.sent) {
+		s3Error(w, r, http.StatusBadRequest, "InvalidPart", strconv.Itoa(upload.sent[len(complete.Parts)]))
+		return
+	}
+
+	for _, part := range complete.Parts[len(upload.sent):] {
+		if err := gateway.sendPart(upload, part.PartNumber); err != nil {
+			gateway.s3ApiError(w, r, err, "")
+			return
+		}
+	}
+
+	root, remote := gateway.root(), gateway.remote(upload.bucket, upload.key)
+	var metadata *PathMetadata
+	var err *ApiError
+	if len(upload.uploadId) == 0 {
+		// only empty parts, there was no chunk to send
+		metadata, err = gateway.Api.PutFile(strings.NewReader(""), root, remote, "", true)
+	} else {
+		metadata, err = gateway.Api.CommitChunkedUpload_(root, remote, upload.uploadId, "", true)
+	}
+	if err != nil {
+		gateway.s3ApiError(w, r, err, "NoSuchUpload")
+		return
+	}
+
+	gateway.discard(upload)
+	gateway.forget(id)
+	writeS3Xml(w, http.StatusOK, &s3CompleteMultipartUploadResult{Location: r.URL.Path,
+		Bucket: upload.bucket, Key: upload.key, ETag: `"` + metadata.Rev + `"`})
+}
+
+func (gateway *S3Gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request, id string) {
+	upload := gateway.upload(w, r, id)
+	if upload == nil {
+		return
+	}
+
+	upload.mu.Lock()
+	gateway.discard(upload)
+	upload.mu.Unlock()
+
+	gateway.forget(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// discard removes the parts held in temporary files.
+func (gateway *S3Gateway) discard(upload *s3Upload) {
+	for number, part := range upload.parts {
+		os.Remove(part.file)
+		delete(upload.parts, number)
+	}
+}
+
+// s3Body is the body of a request, with the aws-chunked encoding of
+// streaming signed requests removed.
+func s3Body(r *http.Request) io.Reader {
+	if strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return &awsChunkedReader{reader: bufio.NewReader(r.Body)}
+	}
+	return r.Body
+}
+
+var errAwsChunked = errors.New("malformed aws-chunked body")
+
+// awsChunkedReader decodes "size;chunk-signature=...\r\ndata\r\n" chunks up
+// to the empty one. Signatures are not checked.
+type awsChunkedReader struct {
+	reader *bufio.Reader
+	left   int64
+	done   bool
+}
+
+func (chunked *awsChunkedReader) Read(p []byte) (int, error) {
+	for chunked.left == 0 {
+		if chunked.done {
+			return 0, io.EOF
+		}
+		line, err := chunked.reader.ReadString('\n')
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			// the line break after a chunk
+			continue
+		}
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		size, converr := strconv.ParseInt(line, 16, 64)
+		if converr != nil || size < 0 {
+			return 0, errAwsChunked
+		}
+		if size == 0 {
+			chunked.done = true
+			return 0, io.EOF
+		}
+		chunked.left = size
+	}
+
+	if int64(len(p)) > chunked.left {
+		p = p[:chunked.left]
+	}
+	n, err := chunked.reader.Read(p)
+	chunked.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func s3Time(dropboxTime string) string {
+	t, err := parseDropboxTime(dropboxTime)
+	if err != nil {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func writeS3Xml(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+type s3ErrorResult struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+func s3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if len(message) == 0 {
+		message = http.StatusText(status)
+	}
+	if r.Method == "HEAD" {
+		w.WriteHeader(status)
+		return
+	}
+	writeS3Xml(w, status, &s3ErrorResult{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+// s3ApiError answers with the S3 error closest to a Dropbox one, notFound is
+// the code of a 404. A 401 of Dropbox is about our token, not the client: it
+// is logged and answered with a plain 502. A 403 is a key that exists
+// already, answered with 409.
+func (gateway *S3Gateway) s3ApiError(w http.ResponseWriter, r *http.Request, err *ApiError, notFound string) {
+	switch {
+	case err.Code == http.StatusNotFound && len(notFound) > 0:
+		s3Error(w, r, http.StatusNotFound, notFound, err.Error())
+	case err.Code == http.StatusUnauthorized:
+		logUnauthorized(gateway.Api.Logger, err)
+		s3Error(w, r, http.StatusBadGateway, "InternalError", "")
+	case err.Code == http.StatusForbidden:
+		s3Error(w, r, http.StatusConflict, "OperationAborted", err.Error())
+	case err.Code == http.StatusTooManyRequests || err.Code == http.StatusServiceUnavailable:
+		s3Error(w, r, http.StatusServiceUnavailable, "SlowDown", err.Error())
+	case err.Code == http.StatusInsufficientStorage:
+		s3Error(w, r, http.StatusBadRequest, "EntityTooLarge", err.Error())
+	default:
+		s3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
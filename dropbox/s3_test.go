@@ -0,0 +1,224 @@
+package dropbox_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+func bufioReader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+func TestAwsChunkedReader(t *testing.T) {
+	body := "5;chunk-signature=aaa\r\nhello\r\n6;chunk-signature=bbb\r\n world\r\n0;chunk-signature=ccc\r\n\r\n"
+	data, err := ioutil.ReadAll(dropbox.NewAwsChunkedReader(bufioReader(body)))
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("decoded %q, %v", data, err)
+	}
+
+	if _, err = ioutil.ReadAll(dropbox.NewAwsChunkedReader(bufioReader("5;chunk-signature=aaa\r\nhel"))); err == nil {
+		t.Error("a truncated body decoded without error")
+	}
+}
+
+func TestS3Gateway(t *testing.T) {
+	tree := newTree(t, map[string]string{"photos/a.jpg": "jpeg", "photos/2020/b.jpg": "old", "notes/c.txt": "c"})
+	gateway := &dropbox.S3Gateway{Api: tree.Api(), TempDir: t.TempDir()}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	do := func(method, url, body string, header map[string]string) (*http.Response, string) {
+		return webdavRequest(t, method, server.URL+url, body, header)
+	}
+
+	resp, body := do("GET", "/", "", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<Name>photos</Name>") || !strings.Contains(body, "<Name>notes</Name>") {
+		t.Errorf("ListBuckets returned %d:\n%s", resp.StatusCode, body)
+	}
+
+	list := &dropbox.S3ListBucketResult{}
+	resp, body = do("GET", "/photos?list-type=2&delimiter=/", "", nil)
+	if err := xml.Unmarshal([]byte(body), list); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("ListObjectsV2 returned %d %v:\n%s", resp.StatusCode, err, body)
+	}
+	if len(list.Contents) != 1 || list.Contents[0].Key != "a.jpg" || len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0].Prefix != "2020/" {
+		t.Errorf("ListObjectsV2 with delimiter returned %+v", list)
+	}
+
+	list = &dropbox.S3ListBucketResult{}
+	_, body = do("GET", "/photos?list-type=2&max-keys=1", "", nil)
+	xml.Unmarshal([]byte(body), list)
+	if len(list.Contents) != 1 || list.Contents[0].Key != "2020/b.jpg" || !list.IsTruncated {
+		t.Fatalf("first page returned %+v", list)
+	}
+	token := list.NextContinuationToken
+	list = &dropbox.S3ListBucketResult{}
+	_, body = do("GET", "/photos?list-type=2&max-keys=1&continuation-token="+token, "", nil)
+	xml.Unmarshal([]byte(body), list)
+	if len(list.Contents) != 1 || list.Contents[0].Key != "a.jpg" || list.IsTruncated {
+		t.Errorf("second page returned %+v", list)
+	}
+
+	if resp, body = do("GET", "/photos/a.jpg", "", map[string]string{"Range": "bytes=1-2"}); resp.StatusCode != http.StatusPartialContent || body != "pe" {
+		t.Errorf("ranged GetObject returned %d %q", resp.StatusCode, body)
+	}
+	if resp, body = do("GET", "/photos/missing.jpg", "", nil); resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "<Code>NoSuchKey</Code>") {
+		t.Errorf("missing object returned %d:\n%s", resp.StatusCode, body)
+	}
+	if resp, body = do("GET", "/missing?list-type=2", "", nil); resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "<Code>NoSuchBucket</Code>") {
+		t.Errorf("missing bucket returned %d:\n%s", resp.StatusCode, body)
+	}
+
+	header := map[string]string{"x-amz-content-sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"}
+	if resp, _ = do("PUT", "/notes/d.txt", "3;chunk-signature=0\r\nnew\r\n0;chunk-signature=0\r\n\r\n", header); resp.StatusCode != http.StatusOK {
+		t.Errorf("PutObject returned %d", resp.StatusCode)
+	}
+	if got := readRemote(tree, "/notes/d.txt"); got != "new" {
+		t.Errorf("PutObject stored %q", got)
+	}
+
+	if resp, _ = do("PUT", "/notes/c.txt", "", map[string]string{"x-amz-copy-source": "/notes/d.txt"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("CopyObject returned %d", resp.StatusCode)
+	}
+	if got := readRemote(tree, "/notes/c.txt"); got != "new" {
+		t.Errorf("CopyObject stored %q", got)
+	}
+
+	if resp, _ = do("DELETE", "/notes/d.txt", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DeleteObject returned %d", resp.StatusCode)
+	}
+	if resp, _ = do("DELETE", "/notes/d.txt", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DeleteObject of a missing key returned %d", resp.StatusCode)
+	}
+	if _, ok := tree.ReadFile("/notes/d.txt"); ok {
+		t.Error("DeleteObject left the file")
+	}
+}
+
+func TestS3GatewayMultipart(t *testing.T) {
+	tree := newTree(t, map[string]string{"bucket/": ""})
+	gateway := &dropbox.S3Gateway{Api: tree.Api(), TempDir: t.TempDir()}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	do := func(method, url, body string) (*http.Response, string) {
+		return webdavRequest(t, method, server.URL+url, body, nil)
+	}
+
+	initiate := &dropbox.S3InitiateMultipartUploadResult{}
+	_, body := do("POST", "/bucket/big.bin?uploads", "")
+	if err := xml.Unmarshal([]byte(body), initiate); err != nil || len(initiate.UploadId) == 0 {
+		t.Fatalf("CreateMultipartUpload returned %v:\n%s", err, body)
+	}
+	id := initiate.UploadId
+
+	// parts out of order, part 2 waits for part 1
+	etags := map[string]string{}
+	for _, part := range []struct{ number, data string }{{"2", "world"}, {"1", "hello "}, {"3", "!"}} {
+		resp, _ := do("PUT", "/bucket/big.bin?partNumber="+part.number+"&uploadId="+id, part.data)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("UploadPart %s returned %d", part.number, resp.StatusCode)
+		}
+		etags[part.number] = resp.Header.Get("ETag")
+	}
+
+	complete := "<CompleteMultipartUpload>"
+	for _, number := range []string{"1", "2", "3"} {
+		complete += "<Part><PartNumber>" + number + "</PartNumber><ETag>" + etags[number] + "</ETag></Part>"
+	}
+	complete += "</CompleteMultipartUpload>"
+
+	resp, body := do("POST", "/bucket/big.bin?uploadId="+id, complete)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "CompleteMultipartUploadResult") {
+		t.Fatalf("CompleteMultipartUpload returned %d:\n%s", resp.StatusCode, body)
+	}
+	if got := readRemote(tree, "/bucket/big.bin"); got != "hello world!" {
+		t.Errorf("multipart upload stored %q", got)
+	}
+
+	if resp, _ = do("POST", "/bucket/big.bin?uploadId="+id, complete); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("completing twice returned %d", resp.StatusCode)
+	}
+}
+
+func TestS3GatewayStaysInDir(t *testing.T) {
+	tree := newTree(t, map[string]string{"secret.txt": "classified", "public/bucket/a.txt": "a"})
+	gateway := &dropbox.S3Gateway{Api: tree.Api(), Dir: "/public", TempDir: t.TempDir()}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	do := func(method, url string, header map[string]string) (*http.Response, string) {
+		return webdavRequest(t, method, server.URL+url, "", header)
+	}
+
+	for _, url := range []string{"/bucket/../../secret.txt", "/bucket/%2e%2e/%2E%2E/secret.txt", "/../secret.txt", "/bucket/./a.txt"} {
+		if resp, body := do("GET", url, nil); resp.StatusCode != http.StatusBadRequest || strings.Contains(body, "classified") {
+			t.Errorf("GET %s returned %d %q", url, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := do("DELETE", "/bucket/../../secret.txt", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("DELETE out of Dir returned %d", resp.StatusCode)
+	}
+	if resp, _ := do("GET", "/bucket?list-type=2&prefix=../../", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("listing a prefix out of Dir returned %d", resp.StatusCode)
+	}
+
+	for _, source := range []string{"/bucket/../../secret.txt", "bucket/%2e%2e/%2e%2e/secret.txt"} {
+		if resp, _ := do("PUT", "/bucket/copy.txt", map[string]string{"x-amz-copy-source": source}); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("CopyObject from %s returned %d", source, resp.StatusCode)
+		}
+	}
+	if _, ok := tree.ReadFile("/public/bucket/copy.txt"); ok || readRemote(tree, "/secret.txt") != "classified" {
+		t.Error("a request out of Dir reached the file")
+	}
+}
+
+func TestS3GatewayCopyMissingSource(t *testing.T) {
+	tree := newTree(t, map[string]string{"bucket/a.txt": "a"})
+	server := httptest.NewServer(&dropbox.S3Gateway{Api: tree.Api(), TempDir: t.TempDir()})
+	defer server.Close()
+
+	resp, body := webdavRequest(t, "PUT", server.URL+"/bucket/a.txt", "", map[string]string{"x-amz-copy-source": "/bucket/missing.txt"})
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "<Code>NoSuchKey</Code>") {
+		t.Errorf("CopyObject of a missing source returned %d:\n%s", resp.StatusCode, body)
+	}
+	if got := readRemote(tree, "/bucket/a.txt"); got != "a" {
+		t.Errorf("CopyObject of a missing source left the target %q", got)
+	}
+}
+
+func TestS3GatewayApiErrors(t *testing.T) {
+	tree := newTree(t, map[string]string{"bucket/a.txt": "a"})
+	api := tree.Api()
+	var log bytes.Buffer
+	api.Logger = slog.New(slog.NewTextHandler(&log, nil))
+	server := httptest.NewServer(&dropbox.S3Gateway{Api: api, TempDir: t.TempDir()})
+	defer server.Close()
+
+	// a 403 of Dropbox is about the key, not the token
+	resp, body := webdavRequest(t, "PUT", server.URL+"/bucket/a.txt/b.txt", "b", nil)
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(body, "<Code>OperationAborted</Code>") {
+		t.Errorf("PutObject below a file returned %d:\n%s", resp.StatusCode, body)
+	}
+
+	if err := api.RevokeToken(); err != nil {
+		t.Fatal(err)
+	}
+	log.Reset()
+	resp, body = webdavRequest(t, "GET", server.URL+"/bucket/a.txt", "", nil)
+	if resp.StatusCode != http.StatusBadGateway || strings.Contains(body, "token") {
+		t.Errorf("GetObject with a revoked token returned %d:\n%s", resp.StatusCode, body)
+	}
+	if !strings.Contains(log.String(), "dropbox refused the token") || !strings.Contains(log.String(), "status=401") {
+		t.Errorf("the refused token was not logged:\n%s", log.String())
+	}
+}
@@ -0,0 +1,494 @@
+package dropbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SyncDownload = "download"
+	SyncUpload   = "upload"
+	SyncMkdir    = "mkdir"
+	SyncRemove   = "remove"
+	SyncConflict = "conflict"
+	SyncSkip     = "skip"
+)
+
+var syncOps = []string{SyncDownload, SyncUpload, SyncMkdir, SyncRemove, SyncConflict, SyncSkip}
+
+// SyncOptions describes a folder kept in sync between Dropbox and a local directory.
+type SyncOptions struct {
+	Root       string   // remote root, api.Root when empty
+	RemotePath string   // remote folder, "/" for the whole root
+	LocalDir   string   // local directory
+	CursorFile string   // file keeping the delta cursor between runs, optional
+	StateFile  string   // file keeping what was last uploaded, required by SyncUp and Sync, caches the ignore files for SyncDown
+	DryRun     bool     // only report what would be done
+	Include    []string // path.Match patterns relative to RemotePath, all paths when empty
+	Exclude    []string // path.Match patterns relative to RemotePath
+
+	// Ignore also leaves paths out, the ignore files found in the synced
+	// folders are added to it.
+	Ignore *IgnoreRules
+
+	DeleteRemote bool           // SyncUp deletes remote files removed locally
+	Conflict     ConflictPolicy // how Sync resolves paths changed on both sides
+}
+
+type SyncAction struct {
+	Op     string
+	Path   string // slash separated, relative to RemotePath / LocalDir
+	Bytes  int
+	Remote bool // the action changes Dropbox rather than LocalDir
+	Err    *ApiError
+}
+
+type SyncReport struct {
+	Actions []*SyncAction
+	Cursor  string
+	DryRun  bool
+}
+
+func (report *SyncReport) add(op, rel string, bytes int, err *ApiError) {
+	report.Actions = append(report.Actions, &SyncAction{Op: op, Path: rel, Bytes: bytes, Err: err})
+}
+
+func (report *SyncReport) addRemote(op, rel string, bytes int, err *ApiError) {
+	report.Actions = append(report.Actions, &SyncAction{Op: op, Path: rel, Bytes: bytes, Remote: true, Err: err})
+}
+
+func (report *SyncReport) Count(op string) int {
+	n := 0
+	for _, action := range report.Actions {
+		if action.Op == op && action.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (report *SyncReport) Failed() []*SyncAction {
+	failed := []*SyncAction{}
+	for _, action := range report.Actions {
+		if action.Err != nil {
+			failed = append(failed, action)
+		}
+	}
+	return failed
+}
+
+func (report *SyncReport) String() string {
+	ops := []string{}
+	for _, op := range syncOps {
+		if n := report.Count(op); n > 0 {
+			ops = append(ops, fmt.Sprintf("%s: %d", op, n))
+		}
+	}
+	ops = append(ops, fmt.Sprintf("failed: %d", len(report.Failed())))
+
+	summary := strings.Join(ops, ", ")
+	if report.DryRun {
+		summary += " (dry run)"
+	}
+	return summary
+}
+
+func (opts *SyncOptions) root(api *DropboxApi) string {
+	if len(opts.Root) > 0 {
+		return opts.Root
+	}
+	return api.Root
+}
+
+func (opts *SyncOptions) check(api *DropboxApi) *ApiError {
+	if err := checkRootAndPath(opts.root(api), opts.RemotePath); err != nil {
+		return err
+	}
+	if len(opts.LocalDir) == 0 {
+		return &ApiError{Code: -1, ErrorMsg: "LocalDir is required ."}
+	}
+	return nil
+}
+
+// selected reports whether rel passes the Ignore rules and the Include and
+// Exclude patterns. A pattern matching a parent folder applies to everything
+// below it.
+func (opts *SyncOptions) selected(rel string, isDir bool, size int64) bool {
+	if opts.Ignore.Ignored(rel, isDir, size) {
+		return false
+	}
+	if len(opts.Include) > 0 && !matchPathOrParent(opts.Include, rel) {
+		return false
+	}
+	return !matchPathOrParent(opts.Exclude, rel)
+}
+
+func matchPathOrParent(patterns []string, rel string) bool {
+	for p := rel; p != "." && p != "/" && len(p) > 0; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(p)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func cleanRemotePath(remotePath string) string {
+	p := path.Clean("/" + remotePath)
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+// relRemotePath returns p relative to prefix, comparing case-insensitively
+// like Dropbox does.
+func relRemotePath(prefix, p string) (string, bool) {
+	p = path.Clean("/" + p)
+	if strings.EqualFold(p, prefix) {
+		return "", true
+	}
+	if len(p) <= len(prefix)+1 || !strings.EqualFold(p[:len(prefix)+1], prefix+"/") {
+		return "", false
+	}
+	return p[len(prefix)+1:], true
+}
+
+// resolveLocalPath finds the local file for rel, matching each element
+// case-insensitively when there is no exact match.
+func resolveLocalPath(localDir, rel string) (string, bool) {
+	local := localDir
+	for _, name := range strings.Split(rel, "/") {
+		exact := filepath.Join(local, name)
+		if _, err := os.Lstat(exact); err == nil {
+			local = exact
+			continue
+		}
+
+		infos, err := ioutil.ReadDir(local)
+		if err != nil {
+			return "", false
+		}
+		found := false
+		for _, info := range infos {
+			if strings.EqualFold(info.Name(), name) {
+				local, found = filepath.Join(local, info.Name()), true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return local, true
+}
+
+func localIsDir(localDir, rel string) bool {
+	if local, ok := resolveLocalPath(localDir, rel); ok {
+		if info, err := os.Stat(local); err == nil {
+			return info.IsDir()
+		}
+	}
+	return false
+}
+
+func parseDropboxTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC1123Z, value)
+}
+
+// mtime is the modification time reported by the client that uploaded
+// content, or the server one.
+func (content *Content) mtime() (time.Time, error) {
+	if t, err := parseDropboxTime(content.Client_mtime); err == nil {
+		return t, nil
+	}
+	return parseDropboxTime(content.Modified)
+}
+
+func readCursor(cursorFile string) (string, error) {
+	if len(cursorFile) == 0 {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(cursorFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+func writeCursor(cursorFile, cursor string) error {
+	if len(cursorFile) == 0 {
+		return nil
+	}
+	return writeFileAtomic(cursorFile, []byte(cursor), 0644)
+}
+
+// writeFileAtomic writes data to a temporary file next to name and renames
+// it into place, so readers never see a partial file.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	return writeReaderAtomic(name, bytes.NewReader(data), perm)
+}
+
+// writeReaderAtomic is writeFileAtomic copying from r.
+func writeReaderAtomic(name string, r io.Reader, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// SyncDown mirrors opts.RemotePath into opts.LocalDir: files changed on Dropbox
+// since the cursor saved in opts.CursorFile are downloaded and removed ones are
+// deleted locally. The remote ignore files are cached in opts.StateFile when
+// set, otherwise they are listed again on every run.
+func (api *DropboxApi) SyncDown(opts *SyncOptions) (*SyncReport, *ApiError) {
+	if err := opts.check(api); err != nil {
+		return nil, err
+	}
+
+	cursor, ioerr := readCursor(opts.CursorFile)
+	if ioerr != nil {
+		return nil, api.toApiError(ioerr)
+	}
+
+	state := &SyncState{}
+	if opts.Ignore != nil && len(opts.StateFile) > 0 {
+		if state, ioerr = LoadSyncState(opts.StateFile); ioerr != nil {
+			return nil, api.toApiError(ioerr)
+		}
+	}
+	ignore, err := api.newSyncIgnore(opts.Ignore, opts.root(api), opts.RemotePath, cursor, state.Ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := cleanRemotePath(opts.RemotePath)
+	report := &SyncReport{DryRun: opts.DryRun}
+
+	// after a reset the delta lists every remote file, local files not seen are stale.
+	var seen map[string]bool
+
+	// saved is the cursor written to CursorFile, it stays at the page where
+	// an action failed so that the next run sees those entries again.
+	saved := cursor
+	for {
+		delta, err := api.Delta(cursor)
+		if err == nil {
+			err = ignore.update(cursor, delta)
+		}
+		if err != nil {
+			report.Cursor = saved
+			return report, err
+		}
+
+		if delta.Reset {
+			seen = make(map[string]bool)
+		}
+
+		for _, entry := range delta.Entries {
+			rel, ok := relRemotePath(prefix, entry.Path)
+			if !ok || len(rel) == 0 {
+				continue
+			}
+			if entry.Metadata != nil {
+				if rel, ok = relRemotePath(prefix, entry.Metadata.Path); !ok {
+					continue
+				}
+			}
+			if seen != nil {
+				seen[strings.ToLower(rel)] = true
+			}
+			isDir, size := localIsDir(opts.LocalDir, rel), int64(0)
+			if entry.Metadata != nil {
+				isDir, size = entry.Metadata.Is_dir, int64(entry.Metadata.Bytes)
+			}
+			if !opts.selected(rel, isDir, size) {
+				continue
+			}
+
+			switch {
+			case entry.Metadata == nil:
+				api.syncRemoveLocal(opts, report, rel)
+			case entry.Metadata.Is_dir:
+				api.syncMkdirLocal(opts, report, rel)
+			default:
+				api.syncDownload(opts, report, rel, entry.Metadata)
+			}
+		}
+
+		cursor = delta.Cursor
+		if len(report.Failed()) == 0 {
+			saved = cursor
+			if !opts.DryRun {
+				// the cache is saved first, ahead of the cursor it is replayed.
+				ioerr := api.saveSyncIgnore(opts, state, ignore)
+				if ioerr == nil {
+					ioerr = writeCursor(opts.CursorFile, saved)
+				}
+				if ioerr != nil {
+					report.Cursor = saved
+					return report, api.toApiError(ioerr)
+				}
+			}
+		}
+
+		if !delta.HasMore {
+			break
+		}
+	}
+
+	if seen != nil {
+		api.syncPruneLocal(opts, report, seen)
+	}
+
+	report.Cursor = saved
+	return report, nil
+}
+
+// saveSyncIgnore keeps the ignore files of SyncDown in opts.StateFile.
+func (api *DropboxApi) saveSyncIgnore(opts *SyncOptions, state *SyncState, ignore *syncIgnore) error {
+	if opts.Ignore == nil || len(opts.StateFile) == 0 || len(opts.CursorFile) == 0 {
+		return nil
+	}
+	state.Ignore = ignore.files
+	return state.Save(opts.StateFile)
+}
+
+func (api *DropboxApi) syncRemoveLocal(opts *SyncOptions, report *SyncReport, rel string) {
+	local, ok := resolveLocalPath(opts.LocalDir, rel)
+	if !ok {
+		return
+	}
+
+	var err *ApiError
+	if !opts.DryRun {
+		if ioerr := os.RemoveAll(local); ioerr != nil {
+			err = api.toApiError(ioerr)
+		}
+	}
+	report.add(SyncRemove, rel, 0, err)
+}
+
+func (api *DropboxApi) syncMkdirLocal(opts *SyncOptions, report *SyncReport, rel string) {
+	local := filepath.Join(opts.LocalDir, filepath.FromSlash(rel))
+	info, ioerr := os.Stat(local)
+	if ioerr == nil && info.IsDir() {
+		return
+	}
+
+	var err *ApiError
+	if !opts.DryRun {
+		if ioerr == nil {
+			ioerr = os.Remove(local)
+		} else {
+			ioerr = nil
+		}
+		if ioerr == nil {
+			ioerr = os.MkdirAll(local, 0755)
+		}
+		if ioerr != nil {
+			err = api.toApiError(ioerr)
+		}
+	}
+	report.add(SyncMkdir, rel, 0, err)
+}
+
+func (api *DropboxApi) syncDownload(opts *SyncOptions, report *SyncReport, rel string, metadata *PathMetadata) {
+	local := filepath.Join(opts.LocalDir, filepath.FromSlash(rel))
+	modified, timeErr := metadata.mtime()
+
+	if info, ioerr := os.Stat(local); ioerr == nil && !info.IsDir() && timeErr == nil &&
+		info.Size() == int64(metadata.Bytes) && info.ModTime().Unix() == modified.Unix() {
+		report.add(SyncSkip, rel, 0, nil)
+		return
+	}
+
+	if opts.DryRun {
+		report.add(SyncDownload, rel, metadata.Bytes, nil)
+		return
+	}
+
+	report.add(SyncDownload, rel, metadata.Bytes, api.downloadTo(opts.root(api), metadata.Path, local, modified))
+}
+
+// downloadTo streams path into local, replacing whatever is there.
+func (api *DropboxApi) downloadTo(root, path, local string, modified time.Time) *ApiError {
+	reader, err := api.GetFileReader_(root, path, "")
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if info, ioerr := os.Stat(local); ioerr == nil && info.IsDir() {
+		if ioerr = os.RemoveAll(local); ioerr != nil {
+			return api.toApiError(ioerr)
+		}
+	}
+	if ioerr := os.MkdirAll(filepath.Dir(local), 0755); ioerr != nil {
+		return api.toApiError(ioerr)
+	}
+	if ioerr := writeReaderAtomic(local, reader, 0644); ioerr != nil {
+		return api.toApiError(ioerr)
+	}
+	if !modified.IsZero() {
+		if ioerr := os.Chtimes(local, modified, modified); ioerr != nil {
+			return api.toApiError(ioerr)
+		}
+	}
+	return nil
+}
+
+func (api *DropboxApi) syncPruneLocal(opts *SyncOptions, report *SyncReport, seen map[string]bool) {
+	cursorFile, _ := filepath.Abs(opts.CursorFile)
+
+	filepath.Walk(opts.LocalDir, func(local string, info os.FileInfo, ioerr error) error {
+		if ioerr != nil || local == opts.LocalDir {
+			return nil
+		}
+		if abs, _ := filepath.Abs(local); len(opts.CursorFile) > 0 && abs == cursorFile {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(opts.LocalDir, local)
+		rel = filepath.ToSlash(rel)
+		if seen[strings.ToLower(rel)] || !opts.selected(rel, info.IsDir(), info.Size()) {
+			return nil
+		}
+
+		var err *ApiError
+		if !opts.DryRun {
+			if ioerr := os.RemoveAll(local); ioerr != nil {
+				err = api.toApiError(ioerr)
+			}
+		}
+		report.add(SyncRemove, rel, 0, err)
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,178 @@
+package dropbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelRemotePath(t *testing.T) {
+	cases := []struct {
+		prefix, path, rel string
+		ok                bool
+	}{
+		{"", "/Photos/a.jpg", "Photos/a.jpg", true},
+		{"/photos", "/Photos/a.jpg", "a.jpg", true},
+		{"/photos", "/photos", "", true},
+		{"/photos", "/photos2/a.jpg", "", false},
+		{"/photos", "/other/a.jpg", "", false},
+	}
+
+	for _, c := range cases {
+		rel, ok := relRemotePath(c.prefix, c.path)
+		if rel != c.rel || ok != c.ok {
+			t.Errorf("relRemotePath(%q, %q) = %q, %v; want %q, %v", c.prefix, c.path, rel, ok, c.rel, c.ok)
+		}
+	}
+}
+
+func TestSyncOptionsSelected(t *testing.T) {
+	opts := &SyncOptions{Include: []string{"docs", "*.txt"}, Exclude: []string{"*.tmp", "docs/private"}}
+
+	cases := map[string]bool{
+		"docs/a.pdf":         true,
+		"docs/private/b.pdf": false,
+		"notes.txt":          true,
+		"dir/notes.txt":      true,
+		"docs/x.tmp":         false,
+		"src/main.go":        false,
+	}
+
+	for rel, want := range cases {
+		if got := opts.selected(rel, false, 0); got != want {
+			t.Errorf("selected(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestResolveLocalPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godropbox-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "Photos", "Trip"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "Photos", "Trip", "IMG.jpg"), []byte("x"), 0644)
+
+	local, ok := resolveLocalPath(dir, "photos/trip/img.jpg")
+	if !ok || local != filepath.Join(dir, "Photos", "Trip", "IMG.jpg") {
+		t.Errorf("resolveLocalPath = %q, %v", local, ok)
+	}
+
+	if _, ok := resolveLocalPath(dir, "photos/missing.jpg"); ok {
+		t.Errorf("resolveLocalPath found a missing file")
+	}
+}
+
+func TestSyncReportString(t *testing.T) {
+	report := &SyncReport{DryRun: true}
+	report.add(SyncDownload, "a", 1, nil)
+	report.add(SyncDownload, "b", 1, &ApiError{ErrorMsg: "boom"})
+	report.add(SyncRemove, "c", 0, nil)
+
+	want := "download: 1, remove: 1, failed: 1 (dry run)"
+	if got := report.String(); got != want {
+		t.Errorf("report.String() = %q, want %q", got, want)
+	}
+}
+
+func TestSyncStateSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godropbox-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	state, err := LoadSyncState(stateFile)
+	if err != nil || len(state.Files) != 0 {
+		t.Fatalf("LoadSyncState of a missing file = %v, %v", state, err)
+	}
+
+	state.Put(&SyncFileState{Path: "Docs", IsDir: true})
+	state.Put(&SyncFileState{Path: "Docs/A.txt", Size: 3, Hash: "abc", Rev: "1"})
+	state.Put(&SyncFileState{Path: "b.txt", Rev: "2"})
+	if err = state.Save(stateFile); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSyncState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file := loaded.Get("docs/a.txt"); file == nil || file.Rev != "1" || file.Path != "Docs/A.txt" {
+		t.Errorf("Get(docs/a.txt) = %v", file)
+	}
+
+	loaded.Remove("docs")
+	if len(loaded.Files) != 1 || loaded.Get("b.txt") == nil {
+		t.Errorf("Remove(docs) left %v", loaded.Files)
+	}
+
+	if info, err := os.Stat(stateFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("state file mode = %v, %v", info.Mode(), err)
+	}
+}
+
+func TestJoinRemotePath(t *testing.T) {
+	if p := joinRemotePath("", ""); p != "/" {
+		t.Errorf("joinRemotePath = %q", p)
+	}
+	if p := joinRemotePath("", "a/b"); p != "/a/b" {
+		t.Errorf("joinRemotePath = %q", p)
+	}
+	if p := joinRemotePath("/Photos", "a"); p != "/Photos/a" {
+		t.Errorf("joinRemotePath = %q", p)
+	}
+}
+
+func TestRemoteChanged(t *testing.T) {
+	file := &SyncFileState{Path: "a.txt", Rev: "1"}
+	dir := &SyncFileState{Path: "d", IsDir: true}
+
+	cases := []struct {
+		old      *SyncFileState
+		metadata *PathMetadata
+		want     bool
+	}{
+		{nil, nil, false},
+		{file, nil, true},
+		{nil, &PathMetadata{Content: Content{Rev: "1"}}, true},
+		{file, &PathMetadata{Content: Content{Rev: "1"}}, false},
+		{file, &PathMetadata{Content: Content{Rev: "2"}}, true},
+		{dir, &PathMetadata{Content: Content{Rev: "9", Is_dir: true}}, false},
+		{dir, &PathMetadata{Content: Content{Rev: "9"}}, true},
+	}
+
+	for i, c := range cases {
+		if got := remoteChanged(c.old, c.metadata); got != c.want {
+			t.Errorf("case %d: remoteChanged = %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestConflictedCopyName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godropbox-conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &twoWaySync{host: "box"}
+	local := filepath.Join(dir, "report.txt")
+	ioutil.WriteFile(local, []byte("x"), 0644)
+
+	name := s.conflictedCopyName(local)
+	date := time.Now().Format("2006-01-02")
+	if want := filepath.Join(dir, "report (box's conflicted copy "+date+").txt"); name != want {
+		t.Errorf("conflictedCopyName = %q, want %q", name, want)
+	}
+
+	ioutil.WriteFile(name, []byte("y"), 0644)
+	if want := filepath.Join(dir, "report (box's conflicted copy "+date+" 1).txt"); s.conflictedCopyName(local) != want {
+		t.Errorf("conflictedCopyName = %q, want %q", s.conflictedCopyName(local), want)
+	}
+}
@@ -0,0 +1,215 @@
+package dropbox_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+// readLocal returns the content of the slash separated rel below dir, or
+// "<missing>".
+func readLocal(dir, rel string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+func TestSyncDown(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.WriteFile("/sync/a.txt", []byte("a"))
+	server.WriteFile("/sync/dir/b.txt", []byte("b"))
+	server.WriteFile("/other.txt", []byte("other"))
+
+	api := server.Api()
+	tmp := t.TempDir()
+	local := filepath.Join(tmp, "local")
+	opts := &dropbox.SyncOptions{RemotePath: "/sync", LocalDir: local, CursorFile: filepath.Join(tmp, "cursor")}
+
+	report, err := api.SyncDown(opts)
+	if err != nil || report.Count(dropbox.SyncDownload) != 2 || report.Count(dropbox.SyncMkdir) != 1 || len(report.Failed()) != 0 {
+		t.Fatalf("first SyncDown returned %s, %v", report, err)
+	}
+	if readLocal(local, "a.txt") != "a" || readLocal(local, "dir/b.txt") != "b" || readLocal(local, "other.txt") != "<missing>" {
+		t.Errorf("first SyncDown left a.txt %q, dir/b.txt %q", readLocal(local, "a.txt"), readLocal(local, "dir/b.txt"))
+	}
+
+	if report, err = api.SyncDown(opts); err != nil || len(report.Actions) != 0 {
+		t.Errorf("SyncDown without changes returned %s, %v", report, err)
+	}
+
+	server.WriteFile("/sync/a.txt", []byte("changed"))
+	api.Delete("/sync/dir/b.txt")
+	report, err = api.SyncDown(opts)
+	if err != nil || report.Count(dropbox.SyncDownload) != 1 || report.Count(dropbox.SyncRemove) != 1 {
+		t.Errorf("SyncDown of the changes returned %s, %v", report, err)
+	}
+	if readLocal(local, "a.txt") != "changed" || readLocal(local, "dir/b.txt") != "<missing>" {
+		t.Errorf("SyncDown of the changes left a.txt %q, dir/b.txt %q", readLocal(local, "a.txt"), readLocal(local, "dir/b.txt"))
+	}
+
+	// a reset of the cursor removes local files gone from Dropbox
+	os.Remove(opts.CursorFile)
+	ioutil.WriteFile(filepath.Join(local, "stale.txt"), []byte("stale"), 0644)
+	if report, err = api.SyncDown(opts); err != nil || report.Count(dropbox.SyncRemove) != 1 {
+		t.Errorf("SyncDown after a reset returned %s, %v", report, err)
+	}
+	if readLocal(local, "stale.txt") != "<missing>" || readLocal(local, "a.txt") != "changed" {
+		t.Error("SyncDown after a reset did not prune the stale file")
+	}
+}
+
+func TestSyncDownRetriesFailures(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.WriteFile("/a.txt", []byte("a"))
+	server.WriteFile("/b.txt", []byte("b"))
+
+	api := server.Api()
+	api.Client = &http.Client{Transport: &dropboxtest.FaultTransport{Base: server.Client().Transport,
+		Rules: []dropboxtest.FaultRule{{Fault: dropboxtest.FaultServerError, Path: "/files/", Calls: []int{1}}}}}
+	tmp := t.TempDir()
+	local := filepath.Join(tmp, "local")
+	opts := &dropbox.SyncOptions{RemotePath: "/", LocalDir: local, CursorFile: filepath.Join(tmp, "cursor")}
+
+	report, err := api.SyncDown(opts)
+	if err != nil || len(report.Failed()) != 1 || report.Count(dropbox.SyncDownload) != 1 {
+		t.Fatalf("SyncDown with a failing download returned %s, %v", report, err)
+	}
+	if cursor, _ := ioutil.ReadFile(opts.CursorFile); len(cursor) != 0 || len(report.Cursor) != 0 {
+		t.Errorf("SyncDown saved the cursor %q past a failed download", cursor)
+	}
+
+	report, err = api.SyncDown(opts)
+	if err != nil || len(report.Failed()) != 0 {
+		t.Fatalf("second SyncDown returned %s, %v", report, err)
+	}
+	if readLocal(local, "a.txt") != "a" || readLocal(local, "b.txt") != "b" {
+		t.Errorf("the failed download was not retried, a.txt %q, b.txt %q", readLocal(local, "a.txt"), readLocal(local, "b.txt"))
+	}
+	if cursor, _ := ioutil.ReadFile(opts.CursorFile); len(cursor) == 0 {
+		t.Error("the cursor was not saved once every download succeeded")
+	}
+}
+
+func TestSyncDownKeepsCursorOfFailedPage(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.DeltaPageSize = 1
+	server.WriteFile("/a.txt", []byte("a"))
+	server.WriteFile("/b.txt", []byte("b"))
+	server.WriteFile("/c.txt", []byte("c"))
+
+	api := server.Api()
+	api.Client = &http.Client{Transport: &dropboxtest.FaultTransport{Base: server.Client().Transport,
+		Rules: []dropboxtest.FaultRule{{Fault: dropboxtest.FaultServerError, Path: "/files/", Calls: []int{2}}}}}
+	tmp := t.TempDir()
+	local := filepath.Join(tmp, "local")
+	opts := &dropbox.SyncOptions{RemotePath: "/", LocalDir: local, CursorFile: filepath.Join(tmp, "cursor")}
+
+	// the page of a.txt is done, the one of b.txt failed and c.txt follows it
+	report, err := api.SyncDown(opts)
+	if err != nil || len(report.Failed()) != 1 || report.Count(dropbox.SyncDownload) != 2 {
+		t.Fatalf("SyncDown with a failing page returned %s, %v", report, err)
+	}
+	first, _ := api.Delta("")
+	cursor, _ := ioutil.ReadFile(opts.CursorFile)
+	if string(cursor) != first.Cursor || report.Cursor != first.Cursor {
+		t.Errorf("SyncDown saved the cursor %q, reported %q, want the one of the first page %q", cursor, report.Cursor, first.Cursor)
+	}
+
+	report, err = api.SyncDown(opts)
+	if err != nil || len(report.Failed()) != 0 {
+		t.Fatalf("second SyncDown returned %s, %v", report, err)
+	}
+	for _, action := range report.Actions {
+		if action.Path == "a.txt" {
+			t.Errorf("the done page was synced again: %s", report)
+		}
+	}
+	if readLocal(local, "b.txt") != "b" || readLocal(local, "c.txt") != "c" {
+		t.Errorf("the failed page was not retried, b.txt %q, c.txt %q", readLocal(local, "b.txt"), readLocal(local, "c.txt"))
+	}
+}
+
+// countingTransport records the path of every request.
+type countingTransport struct {
+	base  http.RoundTripper
+	paths []string
+}
+
+func (transport *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.paths = append(transport.paths, req.URL.Path)
+	return transport.base.RoundTrip(req)
+}
+
+func (transport *countingTransport) count(part string) int {
+	n := 0
+	for _, p := range transport.paths {
+		if strings.Contains(p, part) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSyncDownIgnoreFiles(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.WriteFile("/s/.dropboxignore", []byte("*.log\n"))
+	server.WriteFile("/s/a.txt", []byte("a"))
+	server.WriteFile("/s/a.log", []byte("a"))
+	server.WriteFile("/s/sub/b.txt", []byte("b"))
+
+	api := server.Api()
+	transport := &countingTransport{base: server.Client().Transport}
+	api.Client = &http.Client{Transport: transport}
+	tmp := t.TempDir()
+	local := filepath.Join(tmp, "local")
+	// every run starts with fresh rules, like a new process would
+	run := func() *dropbox.SyncReport {
+		transport.paths = nil
+		opts := &dropbox.SyncOptions{RemotePath: "/s", LocalDir: local, CursorFile: filepath.Join(tmp, "cursor"),
+			StateFile: filepath.Join(tmp, "state.json"), Ignore: dropbox.NewIgnoreRules()}
+		report, err := api.SyncDown(opts)
+		if err != nil || len(report.Failed()) != 0 {
+			t.Fatalf("SyncDown returned %s, %v", report, err)
+		}
+		return report
+	}
+
+	run()
+	if readLocal(local, "a.txt") != "a" || readLocal(local, "sub/b.txt") != "b" || readLocal(local, "a.log") != "<missing>" {
+		t.Errorf("first SyncDown ignored nothing, a.log holds %q", readLocal(local, "a.log"))
+	}
+
+	// a new ignore file applies to the page of the delta bringing it
+	server.WriteFile("/s/sub/c.txt", []byte("c"))
+	server.WriteFile("/s/sub/.dropboxignore", []byte("*.txt\n"))
+	server.WriteFile("/s/b.log", []byte("b"))
+	run()
+	if n := transport.count("/metadata/"); n != 0 {
+		t.Errorf("SyncDown listed %d folders with a cursor, requests %v", n, transport.paths)
+	}
+	if readLocal(local, "sub/c.txt") != "<missing>" || readLocal(local, "b.log") != "<missing>" {
+		t.Error("SyncDown did not apply the cached and the new ignore files")
+	}
+
+	api.Delete("/s/.dropboxignore")
+	server.WriteFile("/s/d.log", []byte("d"))
+	run()
+	if readLocal(local, "d.log") != "d" {
+		t.Error("SyncDown kept the rules of a deleted ignore file")
+	}
+	if n := transport.count("/files/"); n != 1 {
+		t.Errorf("SyncDown made %d downloads for one changed file, requests %v", n, transport.paths)
+	}
+}
@@ -0,0 +1,97 @@
+package dropbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// SyncFileState is what a sync run last saw of a file on both sides.
+type SyncFileState struct {
+	Path  string // slash separated, relative to the synced folder
+	IsDir bool
+	Size  int64
+	Mtime int64  // local modification time, unix nanoseconds
+	Hash  string // sha256 of the local content
+	Rev   string // remote revision
+}
+
+// SyncIgnoreFile is an ignore file of the synced folder on Dropbox.
+type SyncIgnoreFile struct {
+	Rev  string
+	Text string
+}
+
+// SyncState is the state database kept in SyncOptions.StateFile.
+type SyncState struct {
+	Cursor string
+	Files  map[string]*SyncFileState // keyed by lower cased Path
+
+	// Ignore holds the remote ignore files by lower cased folder, relative
+	// to the synced folder. It is nil until they were listed once.
+	Ignore map[string]*SyncIgnoreFile
+}
+
+func LoadSyncState(stateFile string) (*SyncState, error) {
+	state := &SyncState{Files: make(map[string]*SyncFileState)}
+
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*SyncFileState)
+	}
+	return state, nil
+}
+
+func (state *SyncState) Save(stateFile string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(stateFile, data, 0600)
+}
+
+func (state *SyncState) Get(rel string) *SyncFileState {
+	return state.Files[strings.ToLower(rel)]
+}
+
+func (state *SyncState) Put(file *SyncFileState) {
+	state.Files[strings.ToLower(file.Path)] = file
+}
+
+// Remove drops rel and, for a folder, everything below it.
+func (state *SyncState) Remove(rel string) {
+	key := strings.ToLower(rel)
+	for k := range state.Files {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			delete(state.Files, k)
+		}
+	}
+}
+
+func hashFile(local string) (string, error) {
+	file, err := os.Open(local)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
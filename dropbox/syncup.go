@@ -0,0 +1,247 @@
+package dropbox
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func joinRemotePath(prefix, rel string) string {
+	if len(rel) == 0 {
+		if len(prefix) == 0 {
+			return "/"
+		}
+		return prefix
+	}
+	return prefix + "/" + rel
+}
+
+// SyncUp pushes opts.LocalDir to opts.RemotePath. Only files changed since the
+// run recorded in opts.StateFile are uploaded, each with the revision it was
+// last uploaded as, so concurrent remote edits end up as conflicted copies
+// instead of being overwritten.
+func (api *DropboxApi) SyncUp(opts *SyncOptions) (*SyncReport, *ApiError) {
+	if err := opts.check(api); err != nil {
+		return nil, err
+	}
+	if len(opts.StateFile) == 0 {
+		return nil, &ApiError{Code: -1, ErrorMsg: "StateFile is required ."}
+	}
+
+	state, ioerr := LoadSyncState(opts.StateFile)
+	if ioerr != nil {
+		return nil, api.toApiError(ioerr)
+	}
+	if opts.Ignore != nil {
+		if ioerr = opts.Ignore.LoadLocal(opts.LocalDir); ioerr != nil {
+			return nil, api.toApiError(ioerr)
+		}
+	}
+
+	prefix := cleanRemotePath(opts.RemotePath)
+	report := &SyncReport{DryRun: opts.DryRun}
+	present := make(map[string]bool)
+
+	ioerr = api.walkLocal(opts, func(rel, local string, info os.FileInfo) {
+		present[strings.ToLower(rel)] = true
+
+		if info.IsDir() {
+			api.syncMkdirRemote(opts, state, report, rel, joinRemotePath(prefix, rel))
+		} else {
+			api.syncUpload(opts, state, report, rel, local, joinRemotePath(prefix, rel), info)
+		}
+	})
+	if ioerr != nil {
+		return report, api.toApiError(ioerr)
+	}
+
+	if opts.DeleteRemote {
+		api.syncRemoveRemote(opts, state, report, prefix, present)
+	}
+
+	if !opts.DryRun {
+		if ioerr = state.Save(opts.StateFile); ioerr != nil {
+			return report, api.toApiError(ioerr)
+		}
+	}
+	return report, nil
+}
+
+// walkLocal calls fn for every selected folder and regular file below
+// opts.LocalDir, leaving out the sync bookkeeping files.
+func (api *DropboxApi) walkLocal(opts *SyncOptions, fn func(rel, local string, info os.FileInfo)) error {
+	skip := make(map[string]bool)
+	for _, name := range []string{opts.CursorFile, opts.StateFile} {
+		if len(name) > 0 {
+			abs, _ := filepath.Abs(name)
+			skip[abs] = true
+		}
+	}
+
+	return filepath.Walk(opts.LocalDir, func(local string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if local == opts.LocalDir {
+			return nil
+		}
+		if abs, _ := filepath.Abs(local); skip[abs] {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(opts.LocalDir, local)
+		rel = filepath.ToSlash(rel)
+		if !opts.selected(rel, info.IsDir(), info.Size()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() || info.Mode().IsRegular() {
+			fn(rel, local, info)
+		}
+		return nil
+	})
+}
+
+func (api *DropboxApi) syncMkdirRemote(opts *SyncOptions, state *SyncState, report *SyncReport, rel, remote string) {
+	if old := state.Get(rel); old != nil && old.IsDir {
+		return
+	}
+
+	var err *ApiError
+	if !opts.DryRun {
+		_, err = api.ensureFolder(opts.root(api), remote)
+		if err == nil {
+			state.Put(&SyncFileState{Path: rel, IsDir: true})
+		}
+	}
+	report.addRemote(SyncMkdir, rel, 0, err)
+}
+
+// ensureFolder creates the folder remote. The 403 Dropbox answers when
+// something is at remote already is only ignored for a folder, a file in the
+// way stays an error.
+func (api *DropboxApi) ensureFolder(root, remote string) (*PathMetadata, *ApiError) {
+	metadata, err := api.CreateFolder_(root, remote)
+	if err == nil || err.Code != http.StatusForbidden {
+		return metadata, err
+	}
+
+	existing, statErr := api.GetFileMetadata_(root, remote, 1, "", false, false, "")
+	if statErr != nil || !existing.Is_dir {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (api *DropboxApi) syncUpload(opts *SyncOptions, state *SyncState, report *SyncReport, rel, local, remote string, info os.FileInfo) {
+	current := &SyncFileState{Path: rel, Size: info.Size(), Mtime: info.ModTime().UnixNano()}
+
+	old := state.Get(rel)
+	if old != nil && old.IsDir {
+		old = nil
+	}
+	if old != nil && old.Size == current.Size && old.Mtime == current.Mtime {
+		report.add(SyncSkip, rel, 0, nil)
+		return
+	}
+
+	hash, ioerr := hashFile(local)
+	if ioerr != nil {
+		report.addRemote(SyncUpload, rel, 0, api.toApiError(ioerr))
+		return
+	}
+	current.Hash = hash
+
+	if old != nil && old.Hash == current.Hash {
+		// touched but not changed.
+		current.Rev = old.Rev
+		if !opts.DryRun {
+			state.Put(current)
+		}
+		report.add(SyncSkip, rel, 0, nil)
+		return
+	}
+
+	if opts.DryRun {
+		report.addRemote(SyncUpload, rel, int(current.Size), nil)
+		return
+	}
+
+	metadata, err := api.uploadFrom(opts.root(api), remote, local, old)
+	if err != nil {
+		report.addRemote(SyncUpload, rel, int(current.Size), err)
+		return
+	}
+
+	if !strings.EqualFold(metadata.Path, remote) {
+		// Dropbox kept the remote edit and stored ours as a conflicted copy,
+		// remember the remote revision so the next local edit replaces it.
+		report.add(SyncConflict, rel, metadata.Bytes, nil)
+		remoteMeta, err := api.GetFileMetadata_(opts.root(api), remote, 1, "", false, false, "")
+		if err != nil {
+			return
+		}
+		current.Rev = remoteMeta.Rev
+	} else {
+		report.addRemote(SyncUpload, rel, metadata.Bytes, nil)
+		current.Rev = metadata.Rev
+	}
+	state.Put(current)
+}
+
+// uploadFrom puts local at remote. A file never uploaded before does not
+// overwrite a remote one, a known file is uploaded with its last revision.
+func (api *DropboxApi) uploadFrom(root, remote, local string, old *SyncFileState) (*PathMetadata, *ApiError) {
+	file, ioerr := os.Open(local)
+	if ioerr != nil {
+		return nil, api.toApiError(ioerr)
+	}
+	defer file.Close()
+
+	if old == nil || len(old.Rev) == 0 {
+		return api.PutFile(file, root, remote, "", false)
+	}
+	return api.PutFile(file, root, remote, old.Rev, true)
+}
+
+func (api *DropboxApi) syncRemoveRemote(opts *SyncOptions, state *SyncState, report *SyncReport, prefix string, present map[string]bool) {
+	vanished := []*SyncFileState{}
+	for key, file := range state.Files {
+		if !present[key] && opts.selected(file.Path, file.IsDir, file.Size) {
+			vanished = append(vanished, file)
+		}
+	}
+	// folders sort before their content, which goes with them.
+	sort.Slice(vanished, func(i, j int) bool {
+		return strings.ToLower(vanished[i].Path) < strings.ToLower(vanished[j].Path)
+	})
+
+	removed := []string{}
+	for _, file := range vanished {
+		key := strings.ToLower(file.Path)
+		if exists(removed, func(dir string) bool { return strings.HasPrefix(key, dir+"/") }) {
+			continue
+		}
+
+		var err *ApiError
+		if !opts.DryRun {
+			_, err = api.Delete_(opts.root(api), joinRemotePath(prefix, file.Path))
+			if err != nil && err.Code == http.StatusNotFound {
+				err = nil
+			}
+			if err == nil {
+				state.Remove(file.Path)
+			}
+		}
+		report.addRemote(SyncRemove, file.Path, 0, err)
+
+		if file.IsDir {
+			removed = append(removed, key)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package dropbox_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+// writeLocal writes content to the slash separated rel below dir.
+func writeLocal(t *testing.T, dir, rel, content string) {
+	name := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRemote(server *dropboxtest.Server, p string) string {
+	data, ok := server.ReadFile(p)
+	if !ok {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+func TestSyncUp(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.Mkdir("/up/docs")
+
+	api := server.Api()
+	tmp := t.TempDir()
+	local := filepath.Join(tmp, "local")
+	writeLocal(t, local, "a.txt", "a")
+	writeLocal(t, local, "docs/b.txt", "b")
+	opts := &dropbox.SyncOptions{RemotePath: "/up", LocalDir: local, StateFile: filepath.Join(tmp, "state.json"), DeleteRemote: true}
+
+	report, err := api.SyncUp(opts)
+	if err != nil || report.Count(dropbox.SyncUpload) != 2 || report.Count(dropbox.SyncMkdir) != 1 || len(report.Failed()) != 0 {
+		t.Fatalf("first SyncUp returned %s, %v", report, err)
+	}
+	if readRemote(server, "/up/a.txt") != "a" || readRemote(server, "/up/docs/b.txt") != "b" {
+		t.Errorf("first SyncUp left a.txt %q, docs/b.txt %q", readRemote(server, "/up/a.txt"), readRemote(server, "/up/docs/b.txt"))
+	}
+
+	if report, err = api.SyncUp(opts); err != nil || report.Count(dropbox.SyncUpload) != 0 || report.Count(dropbox.SyncSkip) != 2 {
+		t.Errorf("SyncUp without changes returned %s, %v", report, err)
+	}
+
+	// an edit made on Dropbox meanwhile is kept, ours becomes a conflicted copy
+	server.WriteFile("/up/a.txt", []byte("remote"))
+	writeLocal(t, local, "a.txt", "local")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(local, "a.txt"), later, later)
+	os.Remove(filepath.Join(local, "docs", "b.txt"))
+	report, err = api.SyncUp(opts)
+	if err != nil || report.Count(dropbox.SyncConflict) != 1 || report.Count(dropbox.SyncRemove) != 1 {
+		t.Errorf("SyncUp of the changes returned %s, %v", report, err)
+	}
+	if readRemote(server, "/up/a.txt") != "remote" || readRemote(server, "/up/a (1).txt") != "local" || readRemote(server, "/up/docs/b.txt") != "<missing>" {
+		t.Errorf("SyncUp of the changes left a.txt %q, a (1).txt %q", readRemote(server, "/up/a.txt"), readRemote(server, "/up/a (1).txt"))
+	}
+}
+
+func TestSyncUpFileInTheWayOfAFolder(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.WriteFile("/up/docs", []byte("a file"))
+
+	api := server.Api()
+	tmp := t.TempDir()
+	local := filepath.Join(tmp, "local")
+	writeLocal(t, local, "docs/b.txt", "b")
+	opts := &dropbox.SyncOptions{RemotePath: "/up", LocalDir: local, StateFile: filepath.Join(tmp, "state.json")}
+
+	report, err := api.SyncUp(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := report.Failed()
+	if len(failed) == 0 || failed[0].Op != dropbox.SyncMkdir || failed[0].Err.Code != http.StatusForbidden {
+		t.Errorf("SyncUp with a file in the way of a folder returned %s, failed %v", report, failed)
+	}
+
+	if report, _ = api.SyncUp(opts); report.Count(dropbox.SyncMkdir) != 0 || len(report.Failed()) == 0 {
+		t.Errorf("the folder was recorded as created, the second SyncUp returned %s", report)
+	}
+}
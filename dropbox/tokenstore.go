@@ -0,0 +1,228 @@
+package dropbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var ErrNoToken = errors.New("no token stored")
+
+// TokenStore keeps an OAuth2 token between runs. Load returns ErrNoToken
+// when there is none yet.
+type TokenStore interface {
+	Load() (*OAuth2, error)
+	Save(token *OAuth2) error
+}
+
+// FileTokenStore keeps the token as JSON in a file only the owner can read.
+type FileTokenStore struct {
+	Path string
+}
+
+func (store *FileTokenStore) Load() (*OAuth2, error) {
+	data, err := ioutil.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(data)
+}
+
+func (store *FileTokenStore) Save(token *OAuth2) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.Path, data, 0600)
+}
+
+// EnvTokenStore reads the token from the <Prefix>_ACCESS_TOKEN, <Prefix>_UID
+// and <Prefix>_TOKEN_TYPE environment variables, Prefix is "DROPBOX" when
+// empty. It is read only.
+type EnvTokenStore struct {
+	Prefix string
+}
+
+func (store *EnvTokenStore) prefix() string {
+	if len(store.Prefix) > 0 {
+		return store.Prefix
+	}
+	return "DROPBOX"
+}
+
+func (store *EnvTokenStore) Load() (*OAuth2, error) {
+	prefix := store.prefix()
+	token := &OAuth2{
+		AccessToken: os.Getenv(prefix + "_ACCESS_TOKEN"),
+		Uid:         os.Getenv(prefix + "_UID"),
+		TokenType:   os.Getenv(prefix + "_TOKEN_TYPE"),
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, ErrNoToken
+	}
+	return token, nil
+}
+
+func (store *EnvTokenStore) Save(token *OAuth2) error {
+	return errors.New("the environment token store is read only")
+}
+
+// EncryptedFileTokenStore keeps the token in a file encrypted with AES-GCM.
+// Key is any secret, it is hashed to an AES-256 key.
+type EncryptedFileTokenStore struct {
+	Path string
+	Key  []byte
+}
+
+func (store *EncryptedFileTokenStore) aead() (cipher.AEAD, error) {
+	if len(store.Key) == 0 {
+		return nil, errors.New("no encryption key")
+	}
+	key := sha256.Sum256(store.Key)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (store *EncryptedFileTokenStore) Load() (*OAuth2, error) {
+	data, err := ioutil.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := store.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("token file is too short")
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(plain)
+}
+
+func (store *EncryptedFileTokenStore) Save(token *OAuth2) error {
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	aead, err := store.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(store.Path, aead.Seal(nonce, nonce, plain, nil), 0600)
+}
+
+func decodeToken(data []byte) (*OAuth2, error) {
+	token := &OAuth2{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, err
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, ErrNoToken
+	}
+	return token, nil
+}
+
+// StoreSigner signs with the token kept in Store, loaded on first use. Once
+// Dropbox answers 401 the token is considered revoked: OnRevoked is called
+// and requests fail until Reset gives a new token.
+type StoreSigner struct {
+	Store     TokenStore
+	OnRevoked func(token *OAuth2)
+
+	mu      sync.Mutex
+	token   *OAuth2
+	revoked bool
+}
+
+func (signer *StoreSigner) Token() (*OAuth2, error) {
+	signer.mu.Lock()
+	defer signer.mu.Unlock()
+
+	if signer.token == nil {
+		token, err := signer.Store.Load()
+		if err != nil {
+			return nil, err
+		}
+		signer.token = token
+	}
+	return signer.token, nil
+}
+
+func (signer *StoreSigner) Revoked() bool {
+	signer.mu.Lock()
+	defer signer.mu.Unlock()
+	return signer.revoked
+}
+
+// Reset saves a new token, after the user authorized the app again.
+func (signer *StoreSigner) Reset(token *OAuth2) error {
+	if err := signer.Store.Save(token); err != nil {
+		return err
+	}
+
+	signer.mu.Lock()
+	defer signer.mu.Unlock()
+	signer.token, signer.revoked = token, false
+	return nil
+}
+
+func (signer *StoreSigner) Sign(req *http.Request) *ApiError {
+	if signer.Revoked() {
+		return tokenRevoked("")
+	}
+
+	token, err := signer.Token()
+	if err != nil {
+		return &ApiError{Code: -1, ErrorMsg: err.Error()}
+	}
+	return token.Sign(req)
+}
+
+// Observe marks the token revoked on a 401. A 401 to a request signed with
+// an older token, one still in flight when Reset was called, is ignored.
+func (signer *StoreSigner) Observe(req *http.Request, resp *http.Response) {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return
+	}
+
+	signer.mu.Lock()
+	token, already := signer.token, signer.revoked
+	if token == nil || !token.signed(req) {
+		signer.mu.Unlock()
+		return
+	}
+	signer.revoked = true
+	signer.mu.Unlock()
+
+	if !already && signer.OnRevoked != nil {
+		signer.OnRevoked(token)
+	}
+}
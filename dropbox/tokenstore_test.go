@@ -0,0 +1,170 @@
+package dropbox
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godropbox-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &FileTokenStore{Path: filepath.Join(dir, "token.json")}
+	if _, err = store.Load(); err != ErrNoToken {
+		t.Errorf("Load of a missing file = %v", err)
+	}
+
+	if err = store.Save(&OAuth2{AccessToken: "tok", Uid: "42"}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := store.Load()
+	if err != nil || token.AccessToken != "tok" || token.Uid != "42" {
+		t.Errorf("Load = %v, %v", token, err)
+	}
+
+	if info, err := os.Stat(store.Path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %v, %v", info.Mode(), err)
+	}
+}
+
+func TestEnvTokenStore(t *testing.T) {
+	store := &EnvTokenStore{Prefix: "GODROPBOX_TEST"}
+	if _, err := store.Load(); err != ErrNoToken {
+		t.Errorf("Load without variables = %v", err)
+	}
+
+	os.Setenv("GODROPBOX_TEST_ACCESS_TOKEN", "tok")
+	os.Setenv("GODROPBOX_TEST_UID", "7")
+	defer os.Unsetenv("GODROPBOX_TEST_ACCESS_TOKEN")
+	defer os.Unsetenv("GODROPBOX_TEST_UID")
+
+	token, err := store.Load()
+	if err != nil || token.AccessToken != "tok" || token.Uid != "7" {
+		t.Errorf("Load = %v, %v", token, err)
+	}
+	if err = store.Save(token); err == nil {
+		t.Errorf("Save to the environment succeeded")
+	}
+}
+
+func TestEncryptedFileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godropbox-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token.enc")
+	store := &EncryptedFileTokenStore{Path: path, Key: []byte("secret")}
+	if err = store.Save(&OAuth2{AccessToken: "plain-token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if bytes.Contains(data, []byte("plain-token")) {
+		t.Errorf("token stored in clear")
+	}
+
+	token, err := store.Load()
+	if err != nil || token.AccessToken != "plain-token" {
+		t.Errorf("Load = %v, %v", token, err)
+	}
+
+	wrong := &EncryptedFileTokenStore{Path: path, Key: []byte("other")}
+	if _, err = wrong.Load(); err == nil {
+		t.Errorf("Load with a wrong key succeeded")
+	}
+}
+
+type memoryTokenStore struct {
+	token *OAuth2
+	loads int
+}
+
+func (store *memoryTokenStore) Load() (*OAuth2, error) {
+	store.loads++
+	if store.token == nil {
+		return nil, ErrNoToken
+	}
+	return store.token, nil
+}
+
+func (store *memoryTokenStore) Save(token *OAuth2) error {
+	store.token = token
+	return nil
+}
+
+func TestStoreSignerRevoked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	store := &memoryTokenStore{token: &OAuth2{AccessToken: "bad"}}
+	revoked := 0
+	signer := &StoreSigner{Store: store, OnRevoked: func(token *OAuth2) { revoked++ }}
+	api := &DropboxApi{Signer: signer}
+
+	if store.loads != 0 {
+		t.Errorf("token loaded before the first request")
+	}
+
+	if _, err := api.doGet(server.URL); err == nil || !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("request with a bad token = %v", err)
+	}
+	if !signer.Revoked() || revoked != 1 {
+		t.Errorf("Revoked = %v, OnRevoked called %d times", signer.Revoked(), revoked)
+	}
+
+	if _, err := api.doGet(server.URL); err == nil || !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("request with a revoked token = %v", err)
+	}
+
+	signer.Reset(&OAuth2{AccessToken: "good"})
+	resp, err := api.doGet(server.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("request after Reset = %v, %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+	if store.token.AccessToken != "good" || store.loads != 1 {
+		t.Errorf("store = %v, loaded %d times", store.token, store.loads)
+	}
+}
+
+func TestStoreSignerLate401(t *testing.T) {
+	store := &memoryTokenStore{token: &OAuth2{AccessToken: "old"}}
+	revoked := 0
+	signer := &StoreSigner{Store: store, OnRevoked: func(token *OAuth2) { revoked++ }}
+
+	// a request signed with the old token is answered after Reset
+	inFlight, _ := http.NewRequest("GET", "https://api.dropbox.com/1/account/info", nil)
+	if err := signer.Sign(inFlight); err != nil {
+		t.Fatal(err)
+	}
+	signer.Reset(&OAuth2{AccessToken: "new"})
+	signer.Observe(inFlight, &http.Response{StatusCode: http.StatusUnauthorized})
+	if signer.Revoked() || revoked != 0 {
+		t.Errorf("a late 401 to the old token revoked the new one")
+	}
+
+	current, _ := http.NewRequest("GET", "https://api.dropbox.com/1/account/info", nil)
+	signer.Sign(current)
+	signer.Observe(current, &http.Response{StatusCode: http.StatusUnauthorized})
+	if !signer.Revoked() || revoked != 1 {
+		t.Errorf("a 401 to the new token did not revoke it, OnRevoked called %d times", revoked)
+	}
+}
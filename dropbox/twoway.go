@@ -0,0 +1,597 @@
+package dropbox
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ConflictPolicy int
+
+const (
+	ConflictKeepBoth     ConflictPolicy = iota // keep the local side as a "conflicted copy"
+	ConflictPreferLocal                        // the local side replaces the remote one
+	ConflictPreferRemote                       // the remote side replaces the local one
+)
+
+type localItem struct {
+	rel   string
+	local string
+	info  os.FileInfo
+	hash  string
+}
+
+// twoWaySync is the state of a single Sync run.
+type twoWaySync struct {
+	api    *DropboxApi
+	opts   *SyncOptions
+	state  *SyncState
+	report *SyncReport
+	root   string
+	prefix string
+	host   string
+
+	remote    map[string]*PathMetadata // remote changes since the cursor, nil when deleted
+	remoteRel map[string]string
+	local     map[string]*localItem // everything found in LocalDir
+	changed   map[string]bool       // local additions, modifications and deletions
+
+	removedLocal  []string // folders gone with their content
+	removedRemote []string
+}
+
+// Sync keeps opts.RemotePath and opts.LocalDir identical. Remote changes come
+// from Delta, local ones from comparing LocalDir with opts.StateFile, and paths
+// changed on both sides are resolved by opts.Conflict.
+func (api *DropboxApi) Sync(opts *SyncOptions) (*SyncReport, *ApiError) {
+	if err := opts.check(api); err != nil {
+		return nil, err
+	}
+	if len(opts.StateFile) == 0 {
+		return nil, &ApiError{Code: -1, ErrorMsg: "StateFile is required ."}
+	}
+
+	state, ioerr := LoadSyncState(opts.StateFile)
+	if ioerr != nil {
+		return nil, api.toApiError(ioerr)
+	}
+
+	host, ioerr := os.Hostname()
+	if ioerr != nil {
+		host = "local"
+	}
+
+	s := &twoWaySync{
+		api:       api,
+		opts:      opts,
+		state:     state,
+		report:    &SyncReport{DryRun: opts.DryRun, Cursor: state.Cursor},
+		root:      opts.root(api),
+		prefix:    cleanRemotePath(opts.RemotePath),
+		host:      host,
+		remote:    make(map[string]*PathMetadata),
+		remoteRel: make(map[string]string),
+		local:     make(map[string]*localItem),
+		changed:   make(map[string]bool),
+	}
+
+	ignore, err := api.newSyncIgnore(opts.Ignore, s.root, opts.RemotePath, state.Cursor, state.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Ignore != nil {
+		if ioerr = opts.Ignore.LoadLocal(opts.LocalDir); ioerr != nil {
+			return nil, api.toApiError(ioerr)
+		}
+	}
+
+	cursor, err := s.fetchRemote(state.Cursor, ignore)
+	if err != nil {
+		return s.report, err
+	}
+	if ioerr = s.scanLocal(); ioerr != nil {
+		return s.report, api.toApiError(ioerr)
+	}
+
+	s.reconcile()
+
+	if opts.DryRun {
+		return s.report, nil
+	}
+
+	// remote changes that failed to apply come again with the old cursor.
+	if len(s.report.Failed()) == 0 {
+		state.Cursor = cursor
+	}
+	if opts.Ignore != nil {
+		state.Ignore = ignore.files
+	}
+	s.report.Cursor = state.Cursor
+	if ioerr = state.Save(opts.StateFile); ioerr != nil {
+		return s.report, api.toApiError(ioerr)
+	}
+	return s.report, nil
+}
+
+func (s *twoWaySync) fetchRemote(cursor string, ignore *syncIgnore) (string, *ApiError) {
+	var seen map[string]bool
+
+	for {
+		delta, err := s.api.Delta(cursor)
+		if err == nil {
+			err = ignore.update(cursor, delta)
+		}
+		if err != nil {
+			return cursor, err
+		}
+
+		if delta.Reset {
+			seen = make(map[string]bool)
+			s.remote = make(map[string]*PathMetadata)
+		}
+
+		for _, entry := range delta.Entries {
+			rel, ok := relRemotePath(s.prefix, entry.Path)
+			if !ok || len(rel) == 0 {
+				continue
+			}
+			if entry.Metadata != nil {
+				if rel, ok = relRemotePath(s.prefix, entry.Metadata.Path); !ok {
+					continue
+				}
+			}
+
+			key := strings.ToLower(rel)
+			if !s.selectedRemote(key, rel, entry.Metadata) {
+				continue
+			}
+
+			if seen != nil {
+				seen[key] = true
+			}
+
+			if entry.Metadata == nil {
+				// a deleted folder takes its content with it.
+				for k := range s.remote {
+					if isUnder(k, key) {
+						delete(s.remote, k)
+					}
+				}
+				for k, file := range s.state.Files {
+					if isUnder(k, key) {
+						s.remote[k], s.remoteRel[k] = nil, file.Path
+					}
+				}
+			}
+			s.remote[key], s.remoteRel[key] = entry.Metadata, rel
+		}
+
+		cursor = delta.Cursor
+		if !delta.HasMore {
+			break
+		}
+	}
+
+	if seen != nil {
+		for k, file := range s.state.Files {
+			if !seen[k] && s.opts.selected(file.Path, file.IsDir, file.Size) {
+				s.remote[k], s.remoteRel[k] = nil, file.Path
+			}
+		}
+	}
+
+	// our own uploads come back with the revision already in the state.
+	for k, metadata := range s.remote {
+		if !remoteChanged(s.state.Files[k], metadata) {
+			delete(s.remote, k)
+		}
+	}
+	return cursor, nil
+}
+
+func (s *twoWaySync) selectedRemote(key, rel string, metadata *PathMetadata) bool {
+	if metadata != nil {
+		return s.opts.selected(rel, metadata.Is_dir, int64(metadata.Bytes))
+	}
+	if old := s.state.Files[key]; old != nil {
+		return s.opts.selected(rel, old.IsDir, old.Size)
+	}
+	return s.opts.selected(rel, false, 0)
+}
+
+func remoteChanged(old *SyncFileState, metadata *PathMetadata) bool {
+	if metadata == nil || old == nil {
+		return metadata != nil || old != nil
+	}
+	if old.IsDir != metadata.Is_dir {
+		return true
+	}
+	return !metadata.Is_dir && old.Rev != metadata.Rev
+}
+
+func (s *twoWaySync) scanLocal() error {
+	err := s.api.walkLocal(s.opts, func(rel, local string, info os.FileInfo) {
+		key := strings.ToLower(rel)
+		item := &localItem{rel: rel, local: local, info: info}
+		s.local[key] = item
+
+		old := s.state.Files[key]
+		switch {
+		case old == nil || old.IsDir != info.IsDir():
+			s.changed[key] = true
+		case info.IsDir():
+		case old.Size == info.Size() && old.Mtime == info.ModTime().UnixNano():
+		default:
+			item.hash, _ = hashFile(local)
+			if item.hash != old.Hash {
+				s.changed[key] = true
+			} else if !s.opts.DryRun {
+				old.Mtime = info.ModTime().UnixNano()
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, file := range s.state.Files {
+		if s.local[k] == nil && s.opts.selected(file.Path, file.IsDir, file.Size) {
+			s.changed[k] = true
+		}
+	}
+	return nil
+}
+
+func (s *twoWaySync) reconcile() {
+	keys := []string{}
+	for k := range s.remote {
+		keys = append(keys, k)
+	}
+	for k := range s.changed {
+		if _, ok := s.remote[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	// folders sort before their content.
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if isUnderAny(key, s.removedLocal) || isUnderAny(key, s.removedRemote) {
+			continue
+		}
+
+		metadata, remoteChanged := s.remote[key]
+		item := s.local[key]
+
+		switch {
+		case remoteChanged && s.changed[key]:
+			s.resolve(key, metadata, item)
+		case remoteChanged && metadata == nil:
+			s.removeLocal(key, false)
+		case remoteChanged:
+			s.pull(metadata)
+		case item == nil:
+			s.removeRemote(key, false)
+		default:
+			s.push(item, s.state.Files[key])
+		}
+	}
+}
+
+func (s *twoWaySync) resolve(key string, metadata *PathMetadata, item *localItem) {
+	switch {
+	case metadata == nil && item == nil:
+		if !s.opts.DryRun {
+			s.state.Remove(s.relOf(key))
+		}
+		return
+	case metadata != nil && item != nil && metadata.Is_dir && item.info.IsDir():
+		if !s.opts.DryRun {
+			s.state.Put(&SyncFileState{Path: item.rel, IsDir: true, Rev: metadata.Rev})
+		}
+		return
+	case metadata != nil && item != nil && !metadata.Is_dir && !item.info.IsDir():
+		if s.sameContent(metadata, item) {
+			if !s.opts.DryRun {
+				s.state.Put(&SyncFileState{Path: item.rel, Size: item.info.Size(),
+					Mtime: item.info.ModTime().UnixNano(), Hash: item.hash, Rev: metadata.Rev})
+			}
+			s.report.add(SyncSkip, item.rel, 0, nil)
+			return
+		}
+	}
+
+	switch s.opts.Conflict {
+	case ConflictPreferRemote:
+		if metadata == nil {
+			s.removeLocal(key, true)
+		} else {
+			s.pull(metadata)
+		}
+	case ConflictPreferLocal:
+		if item == nil {
+			s.removeRemote(key, true)
+		} else if metadata == nil {
+			s.push(item, nil)
+		} else {
+			s.push(item, &SyncFileState{Path: item.rel, IsDir: metadata.Is_dir, Rev: metadata.Rev})
+		}
+	default:
+		// a modification wins over a deletion, two modifications keep both sides.
+		switch {
+		case metadata == nil:
+			s.push(item, nil)
+		case item == nil:
+			s.pull(metadata)
+		default:
+			s.keepBoth(metadata, item)
+		}
+	}
+}
+
+// sameContent tells whether a file added or changed on both sides ended up
+// identical, which needs the remote content as Dropbox has no content hash.
+func (s *twoWaySync) sameContent(metadata *PathMetadata, item *localItem) bool {
+	if int64(metadata.Bytes) != item.info.Size() {
+		return false
+	}
+	if len(item.hash) == 0 {
+		item.hash, _ = hashFile(item.local)
+	}
+
+	hash, err := s.api.hashRemote(s.root, metadata.Path, metadata.Rev)
+	return err == nil && hash == item.hash
+}
+
+// keepBoth moves the local side out of the way as a conflicted copy and
+// pulls the remote side in its place. A conflicted copy of a folder is
+// uploaded by the next run.
+func (s *twoWaySync) keepBoth(metadata *PathMetadata, item *localItem) {
+	copyName := s.conflictedCopyName(item.local)
+	copyRel := path.Join(path.Dir(item.rel), filepath.Base(copyName))
+
+	if s.opts.DryRun {
+		s.report.add(SyncConflict, copyRel, 0, nil)
+		s.pull(metadata)
+		return
+	}
+
+	if ioerr := os.Rename(item.local, copyName); ioerr != nil {
+		s.report.add(SyncConflict, copyRel, 0, s.api.toApiError(ioerr))
+		return
+	}
+	s.report.add(SyncConflict, copyRel, 0, nil)
+
+	key := strings.ToLower(item.rel)
+	if item.info.IsDir() {
+		s.state.Remove(item.rel)
+		s.removedLocal = append(s.removedLocal, key)
+	} else if info, ioerr := os.Stat(copyName); ioerr == nil {
+		s.push(&localItem{rel: copyRel, local: copyName, info: info, hash: item.hash}, nil)
+	}
+
+	s.pull(metadata)
+}
+
+// conflictedCopyName names a conflicted copy the way the Dropbox clients do,
+// "name (host's conflicted copy 2006-01-02).ext".
+func (s *twoWaySync) conflictedCopyName(local string) string {
+	ext := filepath.Ext(local)
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		ext = ""
+	}
+	base := strings.TrimSuffix(local, ext)
+	date := time.Now().Format("2006-01-02")
+
+	name := fmt.Sprintf("%s (%s's conflicted copy %s)%s", base, s.host, date, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s (%s's conflicted copy %s %d)%s", base, s.host, date, i, ext)
+	}
+}
+
+func (s *twoWaySync) relOf(key string) string {
+	if item := s.local[key]; item != nil {
+		return item.rel
+	}
+	if file := s.state.Files[key]; file != nil {
+		return file.Path
+	}
+	if rel, ok := s.remoteRel[key]; ok {
+		return rel
+	}
+	return key
+}
+
+func (s *twoWaySync) pull(metadata *PathMetadata) {
+	rel, _ := relRemotePath(s.prefix, metadata.Path)
+	local := filepath.Join(s.opts.LocalDir, filepath.FromSlash(rel))
+
+	if metadata.Is_dir {
+		info, ioerr := os.Stat(local)
+		if ioerr == nil && info.IsDir() {
+			if !s.opts.DryRun {
+				s.state.Put(&SyncFileState{Path: rel, IsDir: true, Rev: metadata.Rev})
+			}
+			return
+		}
+
+		var err *ApiError
+		if !s.opts.DryRun {
+			if ioerr == nil {
+				ioerr = os.Remove(local)
+			} else {
+				ioerr = nil
+			}
+			if ioerr == nil {
+				ioerr = os.MkdirAll(local, 0755)
+			}
+			if ioerr != nil {
+				err = s.api.toApiError(ioerr)
+			} else {
+				s.state.Put(&SyncFileState{Path: rel, IsDir: true, Rev: metadata.Rev})
+			}
+		}
+		s.report.add(SyncMkdir, rel, 0, err)
+		return
+	}
+
+	if s.opts.DryRun {
+		s.report.add(SyncDownload, rel, metadata.Bytes, nil)
+		return
+	}
+
+	modified, _ := metadata.mtime()
+	err := s.api.downloadTo(s.root, metadata.Path, local, modified)
+	if err == nil {
+		err = s.record(rel, local, metadata.Rev, "")
+	}
+	s.report.add(SyncDownload, rel, metadata.Bytes, err)
+}
+
+func (s *twoWaySync) push(item *localItem, old *SyncFileState) {
+	remote := joinRemotePath(s.prefix, item.rel)
+
+	if s.opts.DryRun {
+		if item.info.IsDir() {
+			s.report.addRemote(SyncMkdir, item.rel, 0, nil)
+		} else {
+			s.report.addRemote(SyncUpload, item.rel, int(item.info.Size()), nil)
+		}
+		return
+	}
+
+	// the remote side has the other type.
+	if old != nil && old.IsDir != item.info.IsDir() {
+		if _, err := s.api.Delete_(s.root, remote); err != nil && err.Code != http.StatusNotFound {
+			s.report.addRemote(SyncRemove, item.rel, 0, err)
+			return
+		}
+		old = nil
+	}
+
+	if item.info.IsDir() {
+		metadata, err := s.api.ensureFolder(s.root, remote)
+		if err == nil {
+			s.state.Put(&SyncFileState{Path: item.rel, IsDir: true, Rev: metadata.Rev})
+		}
+		s.report.addRemote(SyncMkdir, item.rel, 0, err)
+		return
+	}
+
+	metadata, err := s.api.uploadFrom(s.root, remote, item.local, old)
+	if err != nil {
+		s.report.addRemote(SyncUpload, item.rel, int(item.info.Size()), err)
+		return
+	}
+
+	if !strings.EqualFold(metadata.Path, remote) {
+		// changed remotely since the delta, Dropbox kept it and stored ours as a
+		// conflicted copy. The remote change is picked up by the next run.
+		s.report.add(SyncConflict, item.rel, metadata.Bytes, nil)
+		return
+	}
+
+	s.report.addRemote(SyncUpload, item.rel, metadata.Bytes, s.record(item.rel, item.local, metadata.Rev, item.hash))
+}
+
+// record saves what local looks like now as being in sync with rev.
+func (s *twoWaySync) record(rel, local, rev, hash string) *ApiError {
+	info, ioerr := os.Stat(local)
+	if ioerr == nil && len(hash) == 0 {
+		hash, ioerr = hashFile(local)
+	}
+	if ioerr != nil {
+		return s.api.toApiError(ioerr)
+	}
+
+	s.state.Put(&SyncFileState{Path: rel, Size: info.Size(), Mtime: info.ModTime().UnixNano(), Hash: hash, Rev: rev})
+	return nil
+}
+
+// removeLocal deletes key from LocalDir. Unless forced, a folder holding
+// local changes is kept and uploaded again.
+func (s *twoWaySync) removeLocal(key string, force bool) {
+	rel := s.relOf(key)
+	local, ok := resolveLocalPath(s.opts.LocalDir, rel)
+	if !ok {
+		if !s.opts.DryRun {
+			s.state.Remove(rel)
+		}
+		return
+	}
+
+	if !force && s.hasChangesUnder(key, func(k string) bool { return s.changed[k] && s.local[k] != nil }) {
+		if !s.opts.DryRun {
+			delete(s.state.Files, key)
+		}
+		return
+	}
+
+	var err *ApiError
+	if !s.opts.DryRun {
+		if ioerr := os.RemoveAll(local); ioerr != nil {
+			err = s.api.toApiError(ioerr)
+		} else {
+			s.state.Remove(rel)
+		}
+	}
+	s.report.add(SyncRemove, rel, 0, err)
+	s.removedLocal = append(s.removedLocal, key)
+}
+
+// removeRemote deletes key from Dropbox. Unless forced, a folder holding
+// remote changes is kept and downloaded again.
+func (s *twoWaySync) removeRemote(key string, force bool) {
+	rel := s.relOf(key)
+
+	if !force && s.hasChangesUnder(key, func(k string) bool { return s.remote[k] != nil }) {
+		if !s.opts.DryRun {
+			delete(s.state.Files, key)
+		}
+		return
+	}
+
+	var err *ApiError
+	if !s.opts.DryRun {
+		_, err = s.api.Delete_(s.root, joinRemotePath(s.prefix, rel))
+		if err != nil && err.Code == http.StatusNotFound {
+			err = nil
+		}
+		if err == nil {
+			s.state.Remove(rel)
+		}
+	}
+	s.report.addRemote(SyncRemove, rel, 0, err)
+	s.removedRemote = append(s.removedRemote, key)
+}
+
+func (s *twoWaySync) hasChangesUnder(key string, changed func(string) bool) bool {
+	for k := range s.changed {
+		if isUnder(k, key) && k != key && changed(k) {
+			return true
+		}
+	}
+	for k := range s.remote {
+		if isUnder(k, key) && k != key && changed(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnder tells whether key is dir or inside it.
+func isUnder(key, dir string) bool {
+	return key == dir || strings.HasPrefix(key, dir+"/")
+}
+
+func isUnderAny(key string, dirs []string) bool {
+	return exists(dirs, func(dir string) bool { return isUnder(key, dir) && key != dir })
+}
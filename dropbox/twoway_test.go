@@ -0,0 +1,144 @@
+package dropbox_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+func newSync(t *testing.T, server *dropboxtest.Server) (*dropbox.DropboxApi, *dropbox.SyncOptions) {
+	tmp := t.TempDir()
+	opts := &dropbox.SyncOptions{RemotePath: "/s", LocalDir: filepath.Join(tmp, "local"), StateFile: filepath.Join(tmp, "state.json")}
+	os.MkdirAll(opts.LocalDir, 0755)
+	return server.Api(), opts
+}
+
+// touch moves the modification time of a local file ahead, so a rewrite of
+// the same size within the mtime resolution is seen.
+func touch(dir, rel string) {
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, filepath.FromSlash(rel)), later, later)
+}
+
+func TestSync(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.WriteFile("/s/remote.txt", []byte("remote"))
+	api, opts := newSync(t, server)
+	writeLocal(t, opts.LocalDir, "docs/local.txt", "local")
+
+	report, err := api.Sync(opts)
+	if err != nil || report.Count(dropbox.SyncDownload) != 1 || report.Count(dropbox.SyncUpload) != 1 ||
+		report.Count(dropbox.SyncMkdir) != 1 || len(report.Failed()) != 0 {
+		t.Fatalf("first Sync returned %s, %v", report, err)
+	}
+	if readLocal(opts.LocalDir, "remote.txt") != "remote" || readRemote(server, "/s/docs/local.txt") != "local" {
+		t.Errorf("first Sync left remote.txt %q, docs/local.txt %q", readLocal(opts.LocalDir, "remote.txt"), readRemote(server, "/s/docs/local.txt"))
+	}
+
+	for i := 0; i < 2; i++ {
+		if report, err = api.Sync(opts); err != nil || len(report.Actions) != 0 {
+			t.Errorf("Sync without changes returned %s, %v", report, err)
+		}
+	}
+
+	// both sides changed: the local side is kept as a conflicted copy
+	server.WriteFile("/s/remote.txt", []byte("changed remotely"))
+	writeLocal(t, opts.LocalDir, "remote.txt", "changed locally")
+	touch(opts.LocalDir, "remote.txt")
+	report, err = api.Sync(opts)
+	if err != nil || report.Count(dropbox.SyncConflict) != 1 || report.Count(dropbox.SyncDownload) != 1 || len(report.Failed()) != 0 {
+		t.Fatalf("Sync of a conflict returned %s, %v", report, err)
+	}
+	copies, _ := filepath.Glob(filepath.Join(opts.LocalDir, "remote (*conflicted copy*).txt"))
+	if len(copies) != 1 || readLocal(opts.LocalDir, "remote.txt") != "changed remotely" {
+		t.Fatalf("Sync of a conflict left remote.txt %q and the copies %v", readLocal(opts.LocalDir, "remote.txt"), copies)
+	}
+	copyName := filepath.Base(copies[0])
+	if readLocal(opts.LocalDir, copyName) != "changed locally" || readRemote(server, "/s/"+copyName) != "changed locally" {
+		t.Errorf("the conflicted copy holds %q locally, %q remotely", readLocal(opts.LocalDir, copyName), readRemote(server, "/s/"+copyName))
+	}
+
+	if report, err = api.Sync(opts); err != nil || len(report.Actions) != 0 {
+		t.Errorf("Sync after the conflict returned %s, %v", report, err)
+	}
+
+	// a deletion on one side is applied to the other
+	os.Remove(filepath.Join(opts.LocalDir, copyName))
+	api.Delete("/s/docs")
+	report, err = api.Sync(opts)
+	if err != nil || report.Count(dropbox.SyncRemove) != 2 {
+		t.Errorf("Sync of deletions returned %s, %v", report, err)
+	}
+	if readRemote(server, "/s/"+copyName) != "<missing>" || readLocal(opts.LocalDir, "docs/local.txt") != "<missing>" {
+		t.Error("Sync did not apply the deletions")
+	}
+}
+
+func TestSyncKeepsCursorOnFailure(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.WriteFile("/s/a.txt", []byte("a"))
+	api, opts := newSync(t, server)
+	if report, err := api.Sync(opts); err != nil || len(report.Failed()) != 0 {
+		t.Fatalf("first Sync returned %s, %v", report, err)
+	}
+	before, _ := dropbox.LoadSyncState(opts.StateFile)
+
+	server.WriteFile("/s/b.txt", []byte("b"))
+	server.WriteFile("/s/c.txt", []byte("c"))
+	api.Client = &http.Client{Transport: &dropboxtest.FaultTransport{Base: server.Client().Transport,
+		Rules: []dropboxtest.FaultRule{{Fault: dropboxtest.FaultServerError, Path: "/files/", Calls: []int{1}}}}}
+	report, err := api.Sync(opts)
+	if err != nil || len(report.Failed()) != 1 || report.Count(dropbox.SyncDownload) != 1 {
+		t.Fatalf("Sync with a failing download returned %s, %v", report, err)
+	}
+	after, _ := dropbox.LoadSyncState(opts.StateFile)
+	if after.Cursor != before.Cursor || report.Cursor != before.Cursor {
+		t.Errorf("Sync moved the cursor from %q to %q past a failed download", before.Cursor, after.Cursor)
+	}
+
+	if report, err = api.Sync(opts); err != nil || len(report.Failed()) != 0 || report.Count(dropbox.SyncDownload) != 1 {
+		t.Fatalf("Sync after the failure returned %s, %v", report, err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if got := readLocal(opts.LocalDir, name); got != strings.TrimSuffix(name, ".txt") {
+			t.Errorf("%s holds %q", name, got)
+		}
+	}
+	if after, _ = dropbox.LoadSyncState(opts.StateFile); after.Cursor == before.Cursor {
+		t.Error("the cursor was not saved once every download succeeded")
+	}
+}
+
+func TestSyncCachesIgnoreFiles(t *testing.T) {
+	server := dropboxtest.NewServer()
+	defer server.Close()
+	server.WriteFile("/s/.dropboxignore", []byte("*.log\n"))
+	server.WriteFile("/s/sub/a.txt", []byte("a"))
+	api, opts := newSync(t, server)
+	transport := &countingTransport{base: server.Client().Transport}
+	api.Client = &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		transport.paths = nil
+		opts.Ignore = dropbox.NewIgnoreRules()
+		if report, err := api.Sync(opts); err != nil || len(report.Failed()) != 0 {
+			t.Fatalf("Sync returned %s, %v", report, err)
+		}
+	}
+	if n := transport.count("/metadata/"); n != 0 {
+		t.Errorf("Sync listed %d folders with a cursor, requests %v", n, transport.paths)
+	}
+
+	writeLocal(t, opts.LocalDir, "b.log", "b")
+	if report, err := api.Sync(opts); err != nil || report.Count(dropbox.SyncUpload) != 0 || readRemote(server, "/s/b.log") != "<missing>" {
+		t.Errorf("Sync with the cached rules returned %s, %v", report, err)
+	}
+}
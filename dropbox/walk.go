@@ -0,0 +1,83 @@
+package dropbox
+
+import (
+	"path"
+	"strings"
+)
+
+// SkipDir returned by a WalkFunc skips the folder it was called for, or the
+// rest of the folder holding the file it was called for.
+var SkipDir = &ApiError{Code: -1, ErrorMsg: "skip this folder ."}
+
+// WalkFunc is called by Walk for every folder and file. When listing a
+// folder fails it is called with a nil metadata and the error.
+type WalkFunc func(path string, metadata *PathMetadata, err *ApiError) *ApiError
+
+// Walk visits the tree below path, folders before their content. When rules
+// is not nil ignored paths are left out and the ignore files met on the way
+// are added to rules.
+func (api *DropboxApi) Walk(root, path string, rules *IgnoreRules, fn WalkFunc) *ApiError {
+	if err := checkRootAndPath(root, path); err != nil {
+		return err
+	}
+
+	metadata, err := api.GetFileMetadata_(root, path, 25000, "", true, false, "")
+	if err != nil {
+		if err = fn(path, nil, err); err == SkipDir {
+			err = nil
+		}
+		return err
+	}
+
+	err = api.walk(root, cleanRemotePath(path), metadata, rules, fn)
+	if err == SkipDir {
+		err = nil
+	}
+	return err
+}
+
+func (api *DropboxApi) walk(root, prefix string, metadata *PathMetadata, rules *IgnoreRules, fn WalkFunc) *ApiError {
+	if err := fn(metadata.Path, metadata, nil); err != nil || !metadata.Is_dir {
+		return err
+	}
+
+	if rules != nil {
+		for _, content := range metadata.Contents {
+			if !content.Is_dir && strings.EqualFold(path.Base(content.Path), IgnoreFileName) {
+				if err := api.addRemoteIgnoreFile(rules, root, prefix, content.Path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, content := range metadata.Contents {
+		rel, _ := relRemotePath(prefix, content.Path)
+		if rules.Ignored(rel, content.Is_dir, int64(content.Bytes)) {
+			continue
+		}
+
+		var err *ApiError
+		if content.Is_dir {
+			sub, listErr := api.GetFileMetadata_(root, content.Path, 25000, "", true, false, "")
+			if listErr != nil {
+				err = fn(content.Path, nil, listErr)
+			} else {
+				err = api.walk(root, prefix, sub, rules, fn)
+			}
+			if err == SkipDir {
+				continue
+			}
+		} else {
+			err = fn(content.Path, &PathMetadata{Content: content}, nil)
+			if err == SkipDir {
+				return nil
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package dropbox_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+func newWalkServer() *dropboxtest.Server {
+	server := dropboxtest.NewServer()
+	server.WriteFile("/w/.dropboxignore", []byte("*.log\nskip/\n"))
+	server.WriteFile("/w/a.txt", []byte("a"))
+	server.WriteFile("/w/x.log", []byte("x"))
+	server.WriteFile("/w/skip/.dropboxignore", []byte("*.txt\n"))
+	server.WriteFile("/w/skip/d.txt", []byte("d"))
+	server.WriteFile("/w/sub/.dropboxignore", []byte("*.bin\n"))
+	server.WriteFile("/w/sub/b.txt", []byte("b"))
+	server.WriteFile("/w/sub/c.bin", []byte("c"))
+	server.WriteFile("/w/sub/deep/e.txt", []byte("e"))
+	server.WriteFile("/w/z.txt", []byte("z"))
+	return server
+}
+
+func TestWalk(t *testing.T) {
+	server := newWalkServer()
+	defer server.Close()
+	api := server.Api()
+
+	visited := []string{}
+	walk := func(rules *dropbox.IgnoreRules, skip string) string {
+		visited = visited[:0]
+		err := api.Walk("dropbox", "/w", rules, func(p string, metadata *dropbox.PathMetadata, err *dropbox.ApiError) *dropbox.ApiError {
+			if err != nil {
+				return err
+			}
+			if metadata.Path != p {
+				t.Errorf("called with %s and the metadata of %s", p, metadata.Path)
+			}
+			visited = append(visited, p)
+			if p == skip {
+				return dropbox.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(visited, " ")
+	}
+
+	want := "/w /w/.dropboxignore /w/a.txt /w/skip /w/skip/.dropboxignore /w/skip/d.txt /w/sub /w/sub/.dropboxignore " +
+		"/w/sub/b.txt /w/sub/c.bin /w/sub/deep /w/sub/deep/e.txt /w/x.log /w/z.txt"
+	if got := walk(nil, ""); got != want {
+		t.Errorf("Walk visited %s\nwant %s", got, want)
+	}
+
+	want = "/w /w/.dropboxignore /w/a.txt /w/sub /w/sub/.dropboxignore /w/sub/b.txt /w/sub/deep /w/sub/deep/e.txt /w/z.txt"
+	if got := walk(dropbox.NewIgnoreRules(), ""); got != want {
+		t.Errorf("Walk with ignore rules visited %s\nwant %s", got, want)
+	}
+
+	// SkipDir on a folder skips its content, on a file the rest of its folder
+	if got := walk(dropbox.NewIgnoreRules(), "/w/sub"); got != "/w /w/.dropboxignore /w/a.txt /w/sub /w/z.txt" {
+		t.Errorf("Walk skipping /w/sub visited %s", got)
+	}
+	if got := walk(dropbox.NewIgnoreRules(), "/w/sub/b.txt"); got != "/w /w/.dropboxignore /w/a.txt /w/sub /w/sub/.dropboxignore /w/sub/b.txt /w/z.txt" {
+		t.Errorf("Walk skipping after /w/sub/b.txt visited %s", got)
+	}
+
+	err := api.Walk("dropbox", "/missing", nil, func(p string, metadata *dropbox.PathMetadata, err *dropbox.ApiError) *dropbox.ApiError {
+		return err
+	})
+	if err == nil || err.Code != 404 {
+		t.Errorf("Walk of a missing folder returned %v", err)
+	}
+}
+
+func TestLoadIgnoreRules(t *testing.T) {
+	server := newWalkServer()
+	defer server.Close()
+	// more ignore files than a search returns
+	for i := 0; i < 1100; i++ {
+		server.WriteFile(fmt.Sprintf("/w/many/%04d/.dropboxignore", i), []byte("*.tmp\n"))
+	}
+	api := server.Api()
+
+	rules := dropbox.NewIgnoreRules()
+	if err := api.LoadIgnoreRules(rules, "dropbox", "/w"); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"a.txt":            false,
+		"x.log":            true,
+		"sub/c.bin":        true,
+		"sub/deep/f.bin":   true,
+		"c.bin":            false,
+		"skip/d.txt":       true,
+		"many/1099/a.tmp":  true,
+		"many/0000/a.tmp":  true,
+		"many/0000/a.txt":  false,
+		"other/1099/a.tmp": false,
+	}
+	for rel, want := range cases {
+		if got := rules.Ignored(rel, false, 1); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", rel, got, want)
+		}
+	}
+
+	if err := api.LoadIgnoreRules(dropbox.NewIgnoreRules(), "dropbox", "/not/created/yet"); err != nil {
+		t.Errorf("LoadIgnoreRules of a missing folder returned %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package dropbox
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// WebDAVHandler serves a Dropbox folder over WebDAV class 1. LOCK and UNLOCK
+// are accepted but not enforced so clients that lock before writing work, the
+// handler does not claim class 2.
+// Prefix is the path the handler is mounted at.
+type WebDAVHandler struct {
+	Api    *DropboxApi
+	Root   string // Api.Root when empty
+	Dir    string
+	Prefix string
+}
+
+const webdavAllow = "OPTIONS, PROPFIND, GET, HEAD, PUT, MKCOL, MOVE, COPY, DELETE, LOCK, UNLOCK"
+
+func (handler *WebDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := handler.name(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", webdavAllow)
+		w.Header().Set("MS-Author-Via", "DAV")
+	case "PROPFIND":
+		handler.propfind(w, r, name)
+	case "GET", "HEAD":
+		handler.get(w, r, name)
+	case "PUT":
+		handler.put(w, r, name)
+	case "MKCOL":
+		handler.mkcol(w, r, name)
+	case "MOVE", "COPY":
+		handler.moveOrCopy(w, r, name)
+	case "DELETE":
+		// the mounted folder itself is not the client's to delete
+		if name == "/" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if _, err := handler.Api.Delete_(handler.root(), handler.remote(name)); err != nil {
+			writeApiError(w, handler.Api.Logger, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "LOCK":
+		handler.lock(w, r)
+	case "UNLOCK":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", webdavAllow)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *WebDAVHandler) root() string {
+	if len(handler.Root) > 0 {
+		return handler.Root
+	}
+	return handler.Api.Root
+}
+
+// name returns the path below Prefix, "/" for the folder itself.
+func (handler *WebDAVHandler) name(urlPath string) (string, bool) {
+	prefix := strings.TrimSuffix(handler.Prefix, "/")
+	if urlPath != prefix && !strings.HasPrefix(urlPath, prefix+"/") {
+		return "", false
+	}
+	return path.Clean("/" + urlPath[len(prefix):]), true
+}
+
+func (handler *WebDAVHandler) remote(name string) string {
+	return path.Join("/", handler.Dir, name)
+}
+
+func (handler *WebDAVHandler) href(name string, isDir bool) string {
+	href := path.Join("/", handler.Prefix, name)
+	if isDir && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	return (&url.URL{Path: href}).EscapedPath()
+}
+
+func (handler *WebDAVHandler) stat(name string, list bool) (*PathMetadata, *ApiError) {
+	return handler.Api.GetFileMetadata_(handler.root(), handler.remote(name), 25000, "", list, false, "")
+}
+
+func (handler *WebDAVHandler) propfind(w http.ResponseWriter, r *http.Request, name string) {
+	// only allprop is supported, the body asking for properties is ignored
+	io.Copy(ioutil.Discard, r.Body)
+
+	// a missing Depth means infinity, which would list the whole tree
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, webdavFiniteDepthBody)
+		return
+	}
+	metadata, err := handler.stat(name, depth != "0")
+	if err != nil {
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	}
+
+	multistatus := &webdavMultistatus{Xmlns: "DAV:"}
+	multistatus.Responses = append(multistatus.Responses, handler.response(name, &metadata.Content))
+	if depth != "0" {
+		for i := range metadata.Contents {
+			content := &metadata.Contents[i]
+			multistatus.Responses = append(multistatus.Responses,
+				handler.response(path.Join(name, path.Base(content.Path)), content))
+		}
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(multistatus)
+}
+
+const webdavFiniteDepthBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>
+`
+
+func (handler *WebDAVHandler) response(name string, content *Content) webdavResponse {
+	prop := webdavProp{DisplayName: path.Base(name)}
+	if name == "/" {
+		prop.DisplayName = path.Base("/" + handler.Dir)
+	}
+	if mtime, err := content.mtime(); err == nil {
+		prop.LastModified = mtime.UTC().Format(http.TimeFormat)
+	}
+
+	if content.Is_dir {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		prop.ContentLength = fmt.Sprintf("%d", content.Bytes)
+		prop.ContentType = content.Mime_type
+		if len(content.Rev) > 0 {
+			prop.ETag = `"` + content.Rev + `"`
+		}
+	}
+
+	return webdavResponse{
+		Href:     handler.href(name, content.Is_dir),
+		Propstat: webdavPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+	}
+}
+
+func (handler *WebDAVHandler) get(w http.ResponseWriter, r *http.Request, name string) {
+	metadata, err := handler.stat(name, false)
+	if err != nil {
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	}
+	if metadata.Is_dir {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, MKCOL, MOVE, COPY, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Length", fmt.Sprintf("%d", metadata.Bytes))
+	header.Set("ETag", `"`+metadata.Rev+`"`)
+	if len(metadata.Mime_type) > 0 {
+		header.Set("Content-Type", metadata.Mime_type)
+	}
+	if mtime, err := metadata.mtime(); err == nil {
+		header.Set("Last-Modified", mtime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+
+	reader, err := handler.Api.GetFileReader_(handler.root(), handler.remote(name), metadata.Rev)
+	if err != nil {
+		header.Del("Content-Length")
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	}
+	defer reader.Close()
+	io.Copy(w, reader)
+}
+
+func (handler *WebDAVHandler) put(w http.ResponseWriter, r *http.Request, name string) {
+	metadata, err := handler.stat(name, false)
+	switch {
+	case err != nil && err.Code != http.StatusNotFound:
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	case err == nil && metadata.Is_dir:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, puterr := handler.Api.PutFile(r.Body, handler.root(), handler.remote(name), "", true); puterr != nil {
+		writeApiError(w, handler.Api.Logger, puterr)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (handler *WebDAVHandler) mkcol(w http.ResponseWriter, r *http.Request, name string) {
+	if r.ContentLength > 0 {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := handler.stat(name, false); err == nil {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// Dropbox would create missing parents, WebDAV wants a conflict
+	if parent, err := handler.stat(path.Dir(name), false); err != nil || !parent.Is_dir {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	if _, err := handler.Api.CreateFolder_(handler.root(), handler.remote(name)); err != nil {
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (handler *WebDAVHandler) moveOrCopy(w http.ResponseWriter, r *http.Request, name string) {
+	destination, parseErr := url.Parse(r.Header.Get("Destination"))
+	if parseErr != nil || len(destination.Path) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	target, ok := handler.name(destination.Path)
+	if !ok {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if target == name || target == "/" || (name == "/" && r.Method == "MOVE") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// nothing is touched for a missing source
+	if _, err := handler.stat(name, false); err != nil {
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	}
+
+	root, from, to := handler.root(), handler.remote(name), handler.remote(target)
+
+	status := http.StatusCreated
+	var err *ApiError
+	if _, err = handler.stat(target, false); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		status = http.StatusNoContent
+		_, err = handler.Api.replace(root, from, to, r.Method == "COPY")
+	} else if r.Method == "MOVE" {
+		_, err = handler.Api.Move_(root, from, to)
+	} else {
+		_, err = handler.Api.Copy_(root, from, to, "")
+	}
+	if err != nil {
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+const webdavLockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>
+<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>
+<D:depth>0</D:depth><D:timeout>Second-3600</D:timeout>
+<D:locktoken><D:href>%s</D:href></D:locktoken>
+</D:activelock></D:lockdiscovery></D:prop>
+`
+
+// lock hands out a token without locking anything, Dropbox has no locks.
+func (handler *WebDAVHandler) lock(w http.ResponseWriter, r *http.Request) {
+	io.Copy(ioutil.Discard, r.Body)
+
+	id, err := randomState()
+	if err != nil {
+		writeApiError(w, handler.Api.Logger, err)
+		return
+	}
+	token := "opaquelocktoken:" + id
+
+	w.Header().Set("Lock-Token", "<"+token+">")
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	fmt.Fprintf(w, webdavLockBody, token)
+}
+
+// writeApiError answers with the status Dropbox gave, or 502 when there was
+// no answer. A 401 of Dropbox is about our token, not the client: it is
+// logged to logger, when not nil, and answered with a plain 502. A 403 is a
+// path that exists already, answered with 409.
+func writeApiError(w http.ResponseWriter, logger Logger, err *ApiError) {
+	code := err.Code
+	switch {
+	case code == http.StatusUnauthorized:
+		logUnauthorized(logger, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	case code == http.StatusForbidden:
+		code = http.StatusConflict
+	case code < 400 || code > 599:
+		code = http.StatusBadGateway
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// logUnauthorized logs the 401 Dropbox answered, which the clients of the
+// handlers are not told about.
+func logUnauthorized(logger Logger, err *ApiError) {
+	if logger != nil {
+		logger.Warn("dropbox refused the token", "status", err.Code, "error", err.ErrorMsg)
+	}
+}
+
+type webdavMultistatus struct {
+	XMLName   xml.Name         `xml:"D:multistatus"`
+	Xmlns     string           `xml:"xmlns:D,attr"`
+	Responses []webdavResponse `xml:"D:response"`
+}
+
+type webdavResponse struct {
+	Href     string         `xml:"D:href"`
+	Propstat webdavPropstat `xml:"D:propstat"`
+}
+
+type webdavPropstat struct {
+	Prop   webdavProp `xml:"D:prop"`
+	Status string     `xml:"D:status"`
+}
+
+type webdavProp struct {
+	DisplayName   string             `xml:"D:displayname"`
+	ResourceType  webdavResourceType `xml:"D:resourcetype"`
+	ContentLength string             `xml:"D:getcontentlength,omitempty"`
+	LastModified  string             `xml:"D:getlastmodified,omitempty"`
+	ContentType   string             `xml:"D:getcontenttype,omitempty"`
+	ETag          string             `xml:"D:getetag,omitempty"`
+}
+
+type webdavResourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
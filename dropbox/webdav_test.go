@@ -0,0 +1,159 @@
+package dropbox_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+)
+
+func webdavRequest(t *testing.T, method, url, body string, header map[string]string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestWebDAVHandler(t *testing.T) {
+	tree := newTree(t, map[string]string{"a.txt": "hello"})
+	rev, _ := tree.WriteFile("/docs/b.txt", []byte("world"))
+	handler := &dropbox.WebDAVHandler{Api: tree.Api(), Prefix: "/dav"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, body := webdavRequest(t, "OPTIONS", server.URL+"/dav/", "", nil)
+	if resp.Header.Get("DAV") != "1" {
+		t.Errorf("OPTIONS advertised DAV %q", resp.Header.Get("DAV"))
+	}
+
+	resp, body = webdavRequest(t, "PROPFIND", server.URL+"/dav/", "", map[string]string{"Depth": "1"})
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND returned %d", resp.StatusCode)
+	}
+	for _, want := range []string{"<D:href>/dav/</D:href>", "<D:href>/dav/a.txt</D:href>",
+		"<D:href>/dav/docs/</D:href>", "<D:getcontentlength>5</D:getcontentlength>", "<D:collection></D:collection>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("PROPFIND response misses %s:\n%s", want, body)
+		}
+	}
+
+	for _, depth := range []string{"infinity", ""} {
+		resp, body = webdavRequest(t, "PROPFIND", server.URL+"/dav/", "", map[string]string{"Depth": depth})
+		if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "<D:propfind-finite-depth/>") {
+			t.Errorf("PROPFIND with Depth %q returned %d %s", depth, resp.StatusCode, body)
+		}
+	}
+
+	resp, body = webdavRequest(t, "GET", server.URL+"/dav/docs/b.txt", "", nil)
+	if resp.StatusCode != http.StatusOK || body != "world" || resp.Header.Get("ETag") != `"`+rev+`"` {
+		t.Errorf("GET returned %d %q etag %s", resp.StatusCode, body, resp.Header.Get("ETag"))
+	}
+
+	if resp, _ = webdavRequest(t, "PUT", server.URL+"/dav/new.txt", "new", nil); resp.StatusCode != http.StatusCreated {
+		t.Errorf("PUT of a new file returned %d", resp.StatusCode)
+	}
+	if resp, _ = webdavRequest(t, "PUT", server.URL+"/dav/new.txt", "newer", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("PUT over a file returned %d", resp.StatusCode)
+	}
+	if got := readRemote(tree, "/new.txt"); got != "newer" {
+		t.Errorf("PUT stored %q", got)
+	}
+
+	if resp, _ = webdavRequest(t, "MKCOL", server.URL+"/dav/empty", "", nil); resp.StatusCode != http.StatusCreated {
+		t.Errorf("MKCOL returned %d", resp.StatusCode)
+	}
+	if resp, _ = webdavRequest(t, "MKCOL", server.URL+"/dav/missing/empty", "", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("MKCOL without parent returned %d", resp.StatusCode)
+	}
+
+	copyHeader := map[string]string{"Destination": server.URL + "/dav/copy.txt"}
+	if resp, _ = webdavRequest(t, "COPY", server.URL+"/dav/a.txt", "", copyHeader); resp.StatusCode != http.StatusCreated {
+		t.Errorf("COPY returned %d", resp.StatusCode)
+	}
+	moveHeader := map[string]string{"Destination": server.URL + "/dav/copy.txt", "Overwrite": "F"}
+	if resp, _ = webdavRequest(t, "MOVE", server.URL+"/dav/new.txt", "", moveHeader); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("MOVE without overwrite returned %d", resp.StatusCode)
+	}
+	moveHeader["Overwrite"] = "T"
+	if resp, _ = webdavRequest(t, "MOVE", server.URL+"/dav/new.txt", "", moveHeader); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("MOVE over a file returned %d", resp.StatusCode)
+	}
+	if readRemote(tree, "/copy.txt") != "newer" || readRemote(tree, "/a.txt") != "hello" || readRemote(tree, "/new.txt") != "<missing>" {
+		t.Errorf("after COPY and MOVE copy.txt holds %q, a.txt %q", readRemote(tree, "/copy.txt"), readRemote(tree, "/a.txt"))
+	}
+
+	// a missing source leaves the destination alone
+	if resp, _ = webdavRequest(t, "MOVE", server.URL+"/dav/missing.txt", "", moveHeader); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("MOVE of a missing file returned %d", resp.StatusCode)
+	}
+	if resp, _ = webdavRequest(t, "COPY", server.URL+"/dav/missing.txt", "", copyHeader); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("COPY of a missing file returned %d", resp.StatusCode)
+	}
+	if got := readRemote(tree, "/copy.txt"); got != "newer" {
+		t.Errorf("MOVE and COPY of a missing file left copy.txt %q", got)
+	}
+	if resp, _ = webdavRequest(t, "COPY", server.URL+"/dav/a.txt", "", copyHeader); resp.StatusCode != http.StatusNoContent ||
+		readRemote(tree, "/copy.txt") != "hello" {
+		t.Errorf("COPY over a file returned %d, copy.txt holds %q", resp.StatusCode, readRemote(tree, "/copy.txt"))
+	}
+
+	// Dropbox refuses with 403, which is no matter of permissions
+	intoItself := map[string]string{"Destination": server.URL + "/dav/docs/sub"}
+	if resp, _ = webdavRequest(t, "MOVE", server.URL+"/dav/docs", "", intoItself); resp.StatusCode != http.StatusConflict {
+		t.Errorf("MOVE into itself returned %d", resp.StatusCode)
+	}
+
+	// the mounted folder stays, whatever the client asks
+	if resp, _ = webdavRequest(t, "DELETE", server.URL+"/dav/", "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE of the folder returned %d", resp.StatusCode)
+	}
+	moveHeader = map[string]string{"Destination": server.URL + "/dav/moved"}
+	if resp, _ = webdavRequest(t, "MOVE", server.URL+"/dav", "", moveHeader); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("MOVE of the folder returned %d", resp.StatusCode)
+	}
+	if resp, _ = webdavRequest(t, "COPY", server.URL+"/dav/a.txt", "", map[string]string{"Destination": server.URL + "/dav/"}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("COPY onto the folder returned %d", resp.StatusCode)
+	}
+	if readRemote(tree, "/a.txt") != "hello" {
+		t.Error("the folder was touched")
+	}
+
+	if resp, _ = webdavRequest(t, "DELETE", server.URL+"/dav/docs", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE returned %d", resp.StatusCode)
+	}
+	if resp, _ = webdavRequest(t, "DELETE", server.URL+"/dav/docs", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("DELETE of a missing folder returned %d", resp.StatusCode)
+	}
+
+	resp, body = webdavRequest(t, "LOCK", server.URL+"/dav/a.txt", "", nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Lock-Token"), "<opaquelocktoken:") ||
+		!strings.Contains(body, "opaquelocktoken:") {
+		t.Errorf("LOCK returned %d %s", resp.StatusCode, resp.Header.Get("Lock-Token"))
+	}
+}
+
+func TestWebDAVHandlerHidesAuthErrors(t *testing.T) {
+	tree := newTree(t, map[string]string{"a.txt": "a"})
+	tree.AccessToken = "right"
+	api := tree.Api()
+	api.Signer = &dropbox.OAuth2{AccessToken: "wrong"}
+	server := httptest.NewServer(&dropbox.WebDAVHandler{Api: api})
+	defer server.Close()
+
+	for _, method := range []string{"GET", "PROPFIND", "DELETE"} {
+		resp, body := webdavRequest(t, method, server.URL+"/a.txt", "", map[string]string{"Depth": "0"})
+		if resp.StatusCode != http.StatusBadGateway || strings.Contains(body, "token") {
+			t.Errorf("%s with a refused token returned %d %q", method, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package dropbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+const defaultChunkSize = 4 * 1024 * 1024
+
+var errDirNotEmpty = errors.New("directory not empty")
+
+// WritableFile is a file opened by WritableFS.OpenFile.
+type WritableFile interface {
+	fs.File
+	io.Writer
+	io.Seeker
+	Name() string
+}
+
+// WritableFS adds the writing calls of a local disk to DropboxFS, so Dropbox
+// can stand in for it. Files opened for writing are buffered in a temporary
+// file and uploaded when closed, with chunked upload above ChunkSize.
+type WritableFS struct {
+	*DropboxFS
+	ChunkSize int    // 4 MB when 0
+	TempDir   string // os.TempDir() when empty
+}
+
+func NewWritableFS(api *DropboxApi, root, dir string) *WritableFS {
+	return &WritableFS{DropboxFS: NewFS(api, root, dir)}
+}
+
+func (fsys *WritableFS) chunkSize() int {
+	if fsys.ChunkSize > 0 {
+		return fsys.ChunkSize
+	}
+	return defaultChunkSize
+}
+
+func (fsys *WritableFS) Create(name string) (WritableFile, error) {
+	return fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens name like os.OpenFile, perm is ignored. Files opened read
+// only are streamed as DropboxFS.Open does.
+func (fsys *WritableFS) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+	remote, err := fsys.remote("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		file, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &readOnlyFile{dropboxFile: file.(*dropboxFile)}, nil
+	}
+
+	metadata, apierr := fsys.api.GetFileMetadata_(fsys.root, remote, 1, "", false, false, "")
+	exists := apierr == nil
+	switch {
+	case apierr != nil && apierr.Code != http.StatusNotFound:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: apierr}
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case exists && metadata.Is_dir:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	temp, ioerr := ioutil.TempFile(fsys.TempDir, "dropbox-")
+	if ioerr != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ioerr}
+	}
+	file := &writableFile{fsys: fsys, name: name, remote: remote, temp: temp, readable: flag&os.O_RDWR != 0,
+		append: flag&os.O_APPEND != 0}
+
+	if exists && flag&os.O_TRUNC == 0 {
+		if err := file.fetch(metadata.Rev); err != nil {
+			file.discard()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		if _, ioerr = temp.Seek(0, io.SeekStart); ioerr != nil {
+			file.discard()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: ioerr}
+		}
+	}
+	// a created or truncated file is uploaded on Close even when nothing
+	// was written to it.
+	file.dirty = !exists || flag&os.O_TRUNC != 0
+	return file, nil
+}
+
+// Mkdir creates the folder name, Dropbox creates missing parents as well.
+func (fsys *WritableFS) Mkdir(name string, perm fs.FileMode) error {
+	remote, err := fsys.remote("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	if _, apierr := fsys.api.CreateFolder_(fsys.root, remote); apierr != nil {
+		if apierr.Code == http.StatusForbidden {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: apierr}
+	}
+	return nil
+}
+
+func (fsys *WritableFS) MkdirAll(name string, perm fs.FileMode) error {
+	if err := fsys.Mkdir(name, perm); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// Rename moves oldname to newname, replacing newname when both are files.
+func (fsys *WritableFS) Rename(oldname, newname string) error {
+	from, err := fsys.remote("rename", oldname)
+	if err != nil {
+		return err
+	}
+	to, err := fsys.remote("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	_, apierr := fsys.api.Move_(fsys.root, from, to)
+	if apierr != nil && apierr.Code == http.StatusForbidden {
+		// Dropbox refuses to overwrite, a local disk replaces a file
+		target, targetErr := fsys.api.GetFileMetadata_(fsys.root, to, 1, "", false, false, "")
+		source, sourceErr := fsys.api.GetFileMetadata_(fsys.root, from, 1, "", false, false, "")
+		if targetErr == nil && sourceErr == nil && !target.Is_dir && !source.Is_dir {
+			_, apierr = fsys.api.replace(fsys.root, from, to, false)
+		}
+	}
+	if apierr != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: apierr}
+	}
+	return nil
+}
+
+// replace moves, or copies, from onto the file to. Dropbox refuses to
+// overwrite, so to is moved aside first and put back when the move fails. A
+// failure to put it back is part of the error returned, one to delete it
+// afterwards is logged to api.Logger.
+func (api *DropboxApi) replace(root, from, to string, copy bool) (*PathMetadata, *ApiError) {
+	aside := path.Join(path.Dir(to), fmt.Sprintf(".%s.replaced-%d", path.Base(to), time.Now().UnixNano()))
+	if _, err := api.Move_(root, to, aside); err != nil {
+		return nil, err
+	}
+
+	var metadata *PathMetadata
+	var err *ApiError
+	if copy {
+		metadata, err = api.Copy_(root, from, to, "")
+	} else {
+		metadata, err = api.Move_(root, from, to)
+	}
+	if err != nil {
+		if _, restoreErr := api.Move_(root, aside, to); restoreErr != nil {
+			return nil, &ApiError{Code: err.Code, ErrorMsg: fmt.Sprintf("%s, and %s was left at %s: %s",
+				err.ErrorMsg, to, aside, restoreErr.ErrorMsg)}
+		}
+		return nil, err
+	}
+	if _, deleteErr := api.Delete_(root, aside); deleteErr != nil && api.Logger != nil {
+		api.Logger.Warn("dropbox replaced file left behind", "path", aside, "error", deleteErr.ErrorMsg)
+	}
+	return metadata, nil
+}
+
+// Remove removes a file or an empty folder.
+func (fsys *WritableFS) Remove(name string) error {
+	metadata, err := fsys.metadata("remove", name, true)
+	if err != nil {
+		return err
+	}
+	if metadata.Is_dir && len(metadata.Contents) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+	}
+	return fsys.delete("remove", name)
+}
+
+// RemoveAll removes name and everything in it, it is not an error when name
+// does not exist.
+func (fsys *WritableFS) RemoveAll(name string) error {
+	err := fsys.delete("removeall", name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (fsys *WritableFS) delete(op, name string) error {
+	remote, err := fsys.remote(op, name)
+	if err != nil {
+		return err
+	}
+	if _, apierr := fsys.api.Delete_(fsys.root, remote); apierr != nil {
+		return &fs.PathError{Op: op, Path: name, Err: apierr}
+	}
+	return nil
+}
+
+// upload sends the content of file to remote, overwriting it.
+func (fsys *WritableFS) upload(file *os.File, remote string) *ApiError {
+	info, ioerr := file.Stat()
+	if ioerr != nil {
+		return fsys.api.toApiError(ioerr)
+	}
+	if _, ioerr = file.Seek(0, io.SeekStart); ioerr != nil {
+		return fsys.api.toApiError(ioerr)
+	}
+
+	var err *ApiError
+	if info.Size() > int64(fsys.chunkSize()) {
+		_, err = fsys.api.UploadReaderByChunked_(file, fsys.root, remote, "", true, fsys.chunkSize(), 3)
+	} else {
+		_, err = fsys.api.PutFile(file, fsys.root, remote, "", true)
+	}
+	return err
+}
+
+// readOnlyFile is a file opened without write flags.
+type readOnlyFile struct {
+	*dropboxFile
+}
+
+func (file *readOnlyFile) Name() string {
+	return file.name
+}
+
+func (file *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: file.name, Err: fs.ErrPermission}
+}
+
+func (file *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, &fs.PathError{Op: "seek", Path: file.name, Err: fs.ErrInvalid}
+}
+
+// writableFile buffers the file in temp until Close uploads it.
+type writableFile struct {
+	fsys     *WritableFS
+	name     string
+	remote   string
+	temp     *os.File
+	readable bool
+	append   bool // every Write goes to the end, as with os.O_APPEND
+	dirty    bool
+	closed   bool
+}
+
+func (file *writableFile) fetch(rev string) *ApiError {
+	reader, err := file.fsys.api.GetFileReader_(file.fsys.root, file.remote, rev)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if _, ioerr := io.Copy(file.temp, reader); ioerr != nil {
+		return file.fsys.api.toApiError(ioerr)
+	}
+	return nil
+}
+
+func (file *writableFile) discard() {
+	file.temp.Close()
+	os.Remove(file.temp.Name())
+}
+
+func (file *writableFile) Name() string {
+	return file.name
+}
+
+func (file *writableFile) Stat() (fs.FileInfo, error) {
+	info, err := file.temp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &tempInfo{FileInfo: info, name: path.Base(file.name)}, nil
+}
+
+func (file *writableFile) Read(p []byte) (int, error) {
+	if !file.readable {
+		return 0, &fs.PathError{Op: "read", Path: file.name, Err: fs.ErrPermission}
+	}
+	return file.temp.Read(p)
+}
+
+func (file *writableFile) Write(p []byte) (int, error) {
+	if file.closed {
+		return 0, &fs.PathError{Op: "write", Path: file.name, Err: fs.ErrClosed}
+	}
+	if file.append {
+		if _, err := file.temp.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+	file.dirty = true
+	return file.temp.Write(p)
+}
+
+func (file *writableFile) Seek(offset int64, whence int) (int64, error) {
+	return file.temp.Seek(offset, whence)
+}
+
+// Close uploads the file when it was written to.
+func (file *writableFile) Close() error {
+	if file.closed {
+		return &fs.PathError{Op: "close", Path: file.name, Err: fs.ErrClosed}
+	}
+	file.closed = true
+	defer file.discard()
+
+	if !file.dirty {
+		return nil
+	}
+	if err := file.fsys.upload(file.temp, file.remote); err != nil {
+		return &fs.PathError{Op: "close", Path: file.name, Err: err}
+	}
+	return nil
+}
+
+// tempInfo is the fs.FileInfo of the temporary file, under the file name.
+type tempInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (info *tempInfo) Name() string {
+	return info.name
+}
@@ -0,0 +1,152 @@
+package dropbox_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/wen866595/godropbox/dropbox"
+	"github.com/wen866595/godropbox/dropbox/dropboxtest"
+)
+
+func TestWritableFS(t *testing.T) {
+	server := newTree(t, map[string]string{"a.txt": "hello", "docs/b.txt": "world"})
+	fsys := dropbox.NewWritableFS(server.Api(), "", "")
+	fsys.TempDir = t.TempDir()
+
+	file, err := fsys.Create("docs/new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, "created")
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRemote(server, "/docs/new.txt"); got != "created" {
+		t.Errorf("Create uploaded %q", got)
+	}
+
+	file, err = fsys.OpenFile("a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, " world")
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRemote(server, "/a.txt"); got != "hello world" {
+		t.Errorf("append uploaded %q", got)
+	}
+
+	if _, err = fsys.OpenFile("a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("exclusive create of an existing file returned %v", err)
+	}
+	if _, err = fsys.OpenFile("missing.txt", os.O_WRONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("opening a missing file for writing returned %v", err)
+	}
+
+	file, err = fsys.OpenFile("a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(data) != "hello world" {
+		t.Errorf("read %q", data)
+	}
+
+	if err = fsys.Mkdir("empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = fsys.Mkdir("empty", 0755); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir of an existing folder returned %v", err)
+	}
+
+	if err = fsys.Rename("docs/new.txt", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRemote(server, "/a.txt"); got != "created" {
+		t.Errorf("Rename did not replace the target, it holds %q", got)
+	}
+
+	if err = fsys.Remove("docs"); err == nil {
+		t.Error("Remove of a folder with files succeeded")
+	}
+	if err = fsys.Remove("empty"); err != nil {
+		t.Error(err)
+	}
+	if err = fsys.RemoveAll("docs"); err != nil {
+		t.Error(err)
+	}
+	if err = fsys.RemoveAll("docs"); err != nil {
+		t.Errorf("RemoveAll of a missing folder returned %v", err)
+	}
+	if _, err = fsys.Stat("docs/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of a removed file returned %v", err)
+	}
+}
+
+func TestWritableFSRenameKeepsTarget(t *testing.T) {
+	server := newTree(t, map[string]string{"a.txt": "target", "b.txt": "source"})
+	api := server.Api()
+	// the first move is refused, the second moves a.txt aside, the third fails
+	api.Client = &http.Client{Transport: &dropboxtest.FaultTransport{Base: server.Client().Transport,
+		Rules: []dropboxtest.FaultRule{{Fault: dropboxtest.FaultServerError, Path: "/fileops/move", Calls: []int{3}}}}}
+	fsys := dropbox.NewWritableFS(api, "", "")
+
+	if err := fsys.Rename("b.txt", "a.txt"); err == nil {
+		t.Fatal("Rename with a failing move succeeded")
+	}
+	if readRemote(server, "/a.txt") != "target" || readRemote(server, "/b.txt") != "source" {
+		t.Errorf("a failed Rename left a.txt %q, b.txt %q", readRemote(server, "/a.txt"), readRemote(server, "/b.txt"))
+	}
+	if entries, _ := fs.ReadDir(fsys, "."); len(entries) != 2 {
+		t.Errorf("a failed Rename left %d entries", len(entries))
+	}
+
+	if err := fsys.Rename("missing.txt", "a.txt"); !errors.Is(err, fs.ErrNotExist) || readRemote(server, "/a.txt") != "target" {
+		t.Errorf("Rename of a missing file returned %v and left a.txt %q", err, readRemote(server, "/a.txt"))
+	}
+}
+
+func TestWritableFSRenameLeftAside(t *testing.T) {
+	server := newTree(t, map[string]string{"a.txt": "target", "b.txt": "source"})
+	api := server.Api()
+	// the move of b.txt and the one putting a.txt back both fail
+	api.Client = &http.Client{Transport: &dropboxtest.FaultTransport{Base: server.Client().Transport,
+		Rules: []dropboxtest.FaultRule{{Fault: dropboxtest.FaultServerError, Path: "/fileops/move", Calls: []int{3, 4}}}}}
+	fsys := dropbox.NewWritableFS(api, "", "")
+
+	err := fsys.Rename("b.txt", "a.txt")
+	if err == nil || !strings.Contains(err.Error(), "was left at /.a.txt.replaced-") {
+		t.Errorf("Rename failing to restore the target returned %v", err)
+	}
+}
+
+func TestWritableFSAppend(t *testing.T) {
+	server := newTree(t, map[string]string{"a.txt": "hello"})
+	fsys := dropbox.NewWritableFS(server.Api(), "", "")
+	fsys.TempDir = t.TempDir()
+
+	file, err := fsys.OpenFile("a.txt", os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 5)
+	if _, err = io.ReadFull(file, data); err != nil || string(data) != "hello" {
+		t.Errorf("read %q, %v", data, err)
+	}
+	file.(io.Seeker).Seek(0, io.SeekStart)
+	io.WriteString(file, " world")
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRemote(server, "/a.txt"); got != "hello world" {
+		t.Errorf("a write after a seek with O_APPEND uploaded %q", got)
+	}
+}